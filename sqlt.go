@@ -2,14 +2,28 @@ package sqlt
 
 import (
 	"bytes"
+	"container/list"
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"io/fs"
+	"iter"
+	"log/slog"
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
 	"reflect"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"text/template"
@@ -47,6 +61,148 @@ func InTx(ctx context.Context, opts *sql.TxOptions, db *sql.DB, do func(db DB) e
 	return do(tx)
 }
 
+// ReadWriteSplit returns a DB, that routes QueryContext and QueryRowContext to the replica, if the context is
+// read-only (see ReadOnly and WithReadOnly). All other queries (e.g. 'INSERT ... RETURNING' used by ExecReturning)
+// and ExecContext are routed to the primary.
+func ReadWriteSplit(primary, replica *sql.DB) DB {
+	return readWriteSplit{primary: primary, replica: replica}
+}
+
+type readWriteSplit struct {
+	primary *sql.DB
+	replica *sql.DB
+}
+
+func (rws readWriteSplit) route(ctx context.Context) *sql.DB {
+	if IsReadOnly(ctx) {
+		return rws.replica
+	}
+
+	return rws.primary
+}
+
+func (rws readWriteSplit) QueryContext(ctx context.Context, str string, args ...any) (*sql.Rows, error) {
+	return rws.route(ctx).QueryContext(ctx, str, args...)
+}
+
+func (rws readWriteSplit) QueryRowContext(ctx context.Context, str string, args ...any) *sql.Row {
+	return rws.route(ctx).QueryRowContext(ctx, str, args...)
+}
+
+func (rws readWriteSplit) ExecContext(ctx context.Context, str string, args ...any) (sql.Result, error) {
+	return rws.primary.ExecContext(ctx, str, args...)
+}
+
+type readOnlyKey struct{}
+
+// WithReadOnly returns a context, that declares the queries executed with it as read-only (see ReadWriteSplit).
+func WithReadOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readOnlyKey{}, true)
+}
+
+// IsReadOnly reports whether ctx was declared read-only by WithReadOnly or the ReadOnly option.
+func IsReadOnly(ctx context.Context) bool {
+	readOnly, _ := ctx.Value(readOnlyKey{}).(bool)
+
+	return readOnly
+}
+
+// InSavepoint executes do within a savepoint of the transaction tx, e.g. for nested units in InTx.
+// The savepoint is released on success and rolled back on errors and panics.
+// The 'SQLServer' dialect uses 'SAVE TRANSACTION' and does not release savepoints.
+func InSavepoint(ctx context.Context, tx DB, dialect Dialect, name string, do func(db DB) error) (err error) {
+	if !goodName(name) {
+		return fmt.Errorf("invalid savepoint name '%s'", name)
+	}
+
+	save, release, rollback := "SAVEPOINT "+name, "RELEASE SAVEPOINT "+name, "ROLLBACK TO SAVEPOINT "+name
+
+	if dialect == "SQLServer" {
+		save, release, rollback = "SAVE TRANSACTION "+name, "", "ROLLBACK TRANSACTION "+name
+	}
+
+	if _, err = tx.ExecContext(ctx, save); err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil || err != nil {
+			_, rerr := tx.ExecContext(ctx, rollback)
+
+			err = errors.Join(err, toErr(r), rerr)
+		} else if release != "" {
+			_, err = tx.ExecContext(ctx, release)
+		}
+	}()
+
+	return do(tx)
+}
+
+// Copy streams the rows of list into table using 'COPY table (columns) FROM STDIN', as supported by lib/pq.
+// Columns are matched to the fields of T by a 'sql' tag or by the field name. It bypasses the template rendering
+// and returns the number of copied rows.
+func Copy[T any](ctx context.Context, tx *sql.Tx, table string, columns []string, list []T) (int64, error) {
+	if !validIdent(table) {
+		return 0, fmt.Errorf("invalid table name '%s'", table)
+	}
+
+	if len(columns) == 0 {
+		return 0, errors.New("no columns")
+	}
+
+	for _, column := range columns {
+		if !goodName(column) {
+			return 0, fmt.Errorf("invalid column name '%s'", column)
+		}
+	}
+
+	rows := make([][]any, len(list))
+
+	for i, item := range list {
+		value := reflect.ValueOf(item)
+
+		for value.Kind() == reflect.Pointer {
+			value = value.Elem()
+		}
+
+		if value.Kind() != reflect.Struct {
+			return 0, fmt.Errorf("invalid type %T: expected struct", item)
+		}
+
+		rows[i] = make([]any, len(columns))
+
+		for j, column := range columns {
+			field, ok := fieldByColumn(value, column, false)
+			if !ok {
+				return 0, fmt.Errorf("no field for column '%s' in type %s", column, value.Type())
+			}
+
+			rows[i][j] = field.Interface()
+		}
+	}
+
+	stmt, err := tx.PrepareContext(ctx, "COPY "+table+" ("+strings.Join(columns, ", ")+") FROM STDIN")
+	if err != nil {
+		return 0, fmt.Errorf("copy is not supported by the driver: %w", err)
+	}
+
+	defer func() {
+		_ = stmt.Close()
+	}()
+
+	for _, row := range rows {
+		if _, err = stmt.ExecContext(ctx, row...); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err = stmt.ExecContext(ctx); err != nil {
+		return 0, err
+	}
+
+	return int64(len(rows)), nil
+}
+
 func toErr(r any) error {
 	if r == nil {
 		return nil
@@ -66,10 +222,42 @@ type Option interface {
 
 // Config groups the available options.
 type Config struct {
-	Start           Start
-	End             End
-	Placeholder     Placeholder
-	TemplateOptions []TemplateOption
+	Start            Start
+	End              End
+	Placeholder      Placeholder
+	Named            Named
+	Dialect          Dialect
+	Truncate         TruncateMode
+	SlowRender       SlowRender
+	Retry            RetryPolicy
+	Prepare          Prepare
+	Validate         Validate
+	ValidateParam    ValidateParam
+	Tracer           Tracer
+	Comment          Comment
+	Whitespace       PreserveWhitespace
+	DialectFunc      DialectFunc
+	MaxSQLSize       MaxSQLSize
+	OnError          OnError
+	RequireAllFields RequireAllFields
+	Timeout          Timeout
+	MarshalArgs      MarshalArgs
+	UnsafeReuseArgs  UnsafeReuseArgs
+	IntervalArgs     IntervalArgs
+	Deterministic    AssertDeterministic
+	CompactParens    CompactParens
+	Registry         *Registry
+	Rewrite          []Rewrite
+	Schema           Schema
+	FoldCase         FoldCase
+	PrependArgs      PrependArgs
+	ArrayArg         ArrayArg
+	MapRow           MapRow
+	ReadOnly         ReadOnly
+	TemplateOptions  []TemplateOption
+	// placeholderSet is true, if the Placeholder or Named option was set explicitly.
+	// The defaults of a dialect do not override it.
+	placeholderSet bool
 }
 
 // Configure implements the Option interface.
@@ -82,8 +270,124 @@ func (c Config) Configure(config *Config) {
 		config.End = c.End
 	}
 
+	if c.Dialect != "" {
+		c.Dialect.Configure(config)
+	}
+
 	if c.Placeholder != "" {
-		config.Placeholder = c.Placeholder
+		c.Placeholder.Configure(config)
+	}
+
+	if c.Named != "" {
+		c.Named.Configure(config)
+	}
+
+	if c.Truncate != TruncateAuto {
+		config.Truncate = c.Truncate
+	}
+
+	if c.SlowRender.Warn != nil {
+		config.SlowRender = c.SlowRender
+	}
+
+	if c.Retry.Attempts > 0 {
+		config.Retry = c.Retry
+	}
+
+	if c.Prepare > 0 {
+		config.Prepare = c.Prepare
+	}
+
+	if c.Validate != nil {
+		config.Validate = c.Validate
+	}
+
+	if c.ValidateParam != nil {
+		config.ValidateParam = c.ValidateParam
+	}
+
+	if c.Tracer != nil {
+		config.Tracer = c.Tracer
+	}
+
+	if c.Comment != nil {
+		config.Comment = c.Comment
+	}
+
+	if c.Whitespace {
+		config.Whitespace = c.Whitespace
+	}
+
+	if c.DialectFunc != nil {
+		config.DialectFunc = c.DialectFunc
+	}
+
+	if c.MaxSQLSize > 0 {
+		config.MaxSQLSize = c.MaxSQLSize
+	}
+
+	if c.OnError != nil {
+		config.OnError = c.OnError
+	}
+
+	if c.RequireAllFields {
+		config.RequireAllFields = c.RequireAllFields
+	}
+
+	if c.Timeout > 0 {
+		config.Timeout = c.Timeout
+	}
+
+	if c.MarshalArgs {
+		config.MarshalArgs = c.MarshalArgs
+	}
+
+	if c.Deterministic {
+		config.Deterministic = c.Deterministic
+	}
+
+	if c.IntervalArgs {
+		config.IntervalArgs = c.IntervalArgs
+	}
+
+	if c.UnsafeReuseArgs {
+		config.UnsafeReuseArgs = c.UnsafeReuseArgs
+	}
+
+	if c.CompactParens {
+		config.CompactParens = c.CompactParens
+	}
+
+	if c.Registry != nil {
+		config.Registry = c.Registry
+	}
+
+	if c.FoldCase {
+		config.FoldCase = c.FoldCase
+	}
+
+	if c.Schema != nil {
+		config.Schema = c.Schema
+	}
+
+	if c.PrependArgs != nil {
+		config.PrependArgs = c.PrependArgs
+	}
+
+	if c.MapRow != nil {
+		config.MapRow = c.MapRow
+	}
+
+	if c.ArrayArg != nil {
+		config.ArrayArg = c.ArrayArg
+	}
+
+	if c.ReadOnly {
+		config.ReadOnly = true
+	}
+
+	if len(c.Rewrite) > 0 {
+		config.Rewrite = append(config.Rewrite, c.Rewrite...)
 	}
 
 	if len(c.TemplateOptions) > 0 {
@@ -91,6 +395,17 @@ func (c Config) Configure(config *Config) {
 	}
 }
 
+// copy returns a shallow copy of c, whose appended slices do not share their backing arrays with c,
+// so that configuring the copy (e.g. per run) never writes into c.
+func (c *Config) copy() Config {
+	config := *c
+
+	config.Rewrite = slices.Clip(config.Rewrite)
+	config.TemplateOptions = slices.Clip(config.TemplateOptions)
+
+	return config
+}
+
 // Start is executed when a Runner is returned from a statement pool.
 type Start func(runner *Runner)
 
@@ -107,831 +422,5288 @@ func (e End) Configure(config *Config) {
 	config.End = e
 }
 
-// Placeholder can be static or positional using a go-formatted string ('%d').
-type Placeholder string
+// OnError transforms errors returned by a statement, e.g. to map driver-specific errors to domain errors.
+// It is executed after the End option, so End receives the original error. For Iter, the errors are transformed
+// before they are yielded.
+type OnError func(err error, runner *Runner) error
 
 // Configure implements the Option interface.
-func (p Placeholder) Configure(config *Config) {
-	config.Placeholder = p
+func (oe OnError) Configure(config *Config) {
+	config.OnError = oe
 }
 
-// Dollar is a positional placeholder.
-func Dollar() Placeholder {
-	return "$%d"
+// SlowRender is executed when rendering the template of a Runner takes longer than the Threshold.
+// It can be used to detect pathological templates, independent of the database.
+type SlowRender struct {
+	Threshold time.Duration
+	Warn      func(runner *Runner)
 }
 
-// Colon is a positional placeholder.
-func Colon() Placeholder {
-	return ":%d"
+// Configure implements the Option interface.
+func (sr SlowRender) Configure(config *Config) {
+	config.SlowRender = sr
 }
 
-// AtP is a positional placeholder.
-func AtP() Placeholder {
-	return "@p%d"
+// RetryPolicy retries the execution of the rendered sql, if Retryable returns true for the error.
+// Attempts is the maximum number of executions and Backoff returns the delay after a failed attempt.
+// The Start and End options are executed for each attempt, which is recorded in Runner.Attempt.
+type RetryPolicy struct {
+	Attempts  int
+	Backoff   func(attempt int) time.Duration
+	Retryable func(err error) bool
 }
 
-// Question is a static placeholder.
-func Question() Placeholder {
-	return "?"
+// Configure implements the Option interface.
+func (rp RetryPolicy) Configure(config *Config) {
+	config.Retry = rp
 }
 
-// TemplateOption can be used to configure the template of a statement.
-type TemplateOption func(tpl *template.Template) (*template.Template, error)
+// Retry creates a RetryPolicy.
+// Use retryable to match transient errors like serialization failures or deadlocks.
+func Retry(attempts int, backoff func(attempt int) time.Duration, retryable func(err error) bool) RetryPolicy {
+	return RetryPolicy{
+		Attempts:  attempts,
+		Backoff:   backoff,
+		Retryable: retryable,
+	}
+}
+
+// Prepare is the size of a least recently used cache of prepared statements, keyed by the DB and the rendered sql.
+// Transactions (*sql.Tx) bypass the cache, because prepared statements are connection-scoped.
+type Prepare int
 
 // Configure implements the Option interface.
-func (to TemplateOption) Configure(config *Config) {
-	config.TemplateOptions = append(config.TemplateOptions, to)
+func (p Prepare) Configure(config *Config) {
+	config.Prepare = p
 }
 
-// New is equivalent to the method from text/template.
-func New(name string) TemplateOption {
-	return func(tpl *template.Template) (*template.Template, error) {
-		return tpl.New(name), nil
-	}
+// preparer is implemented by *sql.DB, *sql.Conn and *sql.Tx.
+type preparer interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
 }
 
-// Parse is equivalent to the method from text/template.
-func Parse(text string) TemplateOption {
-	return func(tpl *template.Template) (*template.Template, error) {
-		return tpl.Parse(text)
-	}
+type preparedKey struct {
+	db  preparer
+	sql string
 }
 
-// ParseFS is equivalent to the method from text/template.
-func ParseFS(fs fs.FS, patterns ...string) TemplateOption {
-	return func(tpl *template.Template) (*template.Template, error) {
-		return tpl.ParseFS(fs, patterns...)
-	}
+type preparedEntry struct {
+	key     preparedKey
+	stmt    *sql.Stmt
+	refs    int
+	evicted bool
 }
 
-// ParseFiles is equivalent to the method from text/template.
-func ParseFiles(filenames ...string) TemplateOption {
-	return func(tpl *template.Template) (*template.Template, error) {
-		return tpl.ParseFiles(filenames...)
-	}
+// preparedCache is a least recently used cache of prepared statements.
+// Evicted statements are closed as soon as they are released by their last user.
+type preparedCache struct {
+	mu    sync.Mutex
+	size  int
+	list  *list.List
+	items map[preparedKey]*list.Element
+	stats Stats
 }
 
-// ParseGlob is equivalent to the method from text/template.
-func ParseGlob(pattern string) TemplateOption {
-	return func(tpl *template.Template) (*template.Template, error) {
-		return tpl.ParseGlob(pattern)
-	}
+// Stats of the cache of prepared statements.
+type Stats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Len       int
 }
 
-// Funcs is equivalent to the method from text/template.
-func Funcs(fm template.FuncMap) TemplateOption {
-	return func(tpl *template.Template) (*template.Template, error) {
-		return tpl.Funcs(fm), nil
+// Stats returns the current Stats. A nil cache returns empty Stats.
+func (c *preparedCache) Stats() Stats {
+	if c == nil {
+		return Stats{}
 	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Len = c.list.Len()
+
+	return stats
 }
 
-// MissingKeyInvalid is equivalent to the method 'Option("missingkey=invalid")' from text/template.
-func MissingKeyInvalid() TemplateOption {
-	return func(tpl *template.Template) (*template.Template, error) {
-		return tpl.Option("missingkey=invalid"), nil
+func newPreparedCache(size int) *preparedCache {
+	return &preparedCache{
+		size:  size,
+		list:  list.New(),
+		items: map[preparedKey]*list.Element{},
 	}
 }
 
-// MissingKeyZero is equivalent to the method 'Option("missingkey=zero")' from text/template.
-func MissingKeyZero() TemplateOption {
-	return func(tpl *template.Template) (*template.Template, error) {
-		return tpl.Option("missingkey=zero"), nil
+// close closes and removes all prepared statements. A nil cache is a no-op.
+func (c *preparedCache) close() error {
+	if c == nil {
+		return nil
 	}
-}
 
-// MissingKeyError is equivalent to the method 'Option("missingkey=error")' from text/template.
-func MissingKeyError() TemplateOption {
-	return func(tpl *template.Template) (*template.Template, error) {
-		return tpl.Option("missingkey=error"), nil
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var err error
+
+	for elem := c.list.Front(); elem != nil; elem = elem.Next() {
+		err = errors.Join(err, c.evict(elem.Value.(*preparedEntry)))
 	}
+
+	c.list.Init()
+	clear(c.items)
+
+	return err
 }
 
-// Lookup is equivalent to the method from text/template.
-func Lookup(name string) TemplateOption {
-	return func(tpl *template.Template) (*template.Template, error) {
-		tpl = tpl.Lookup(name)
-		if tpl == nil {
-			return nil, fmt.Errorf("template '%s' not found", name)
-		}
+// evict marks entry as evicted and closes the statement, if it is not in use. The caller must hold the lock.
+func (c *preparedCache) evict(entry *preparedEntry) error {
+	entry.evicted = true
 
-		return tpl, nil
+	if entry.refs > 0 {
+		return nil
 	}
+
+	return entry.stmt.Close()
 }
 
-// Raw is used to write strings directly into the sql output.
-// It should be used carefully.
-type Raw string
+// release returns an entry acquired by get. The statement is closed, if it was evicted and this was the last user.
+func (c *preparedCache) release(entry *preparedEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-// A Scanner is used to map columns to struct fields.
-// Value should be a pointer to a struct field.
-type Scanner struct {
-	Value any
-	Map   func() error
-	SQL   string
-}
+	entry.refs--
 
-// Scan is a Scanner for values, that can be used directly with your sql driver.
-func Scan[T any](dest *T, str string) (Scanner, error) {
-	return Scanner{
-		SQL:   str,
-		Value: dest,
-	}, nil
+	if entry.evicted && entry.refs == 0 {
+		_ = entry.stmt.Close()
+	}
 }
 
-var null = []byte("null")
+// get acquires a cached prepared statement and returns whether it was a cache hit.
+// The entry must be released after the execution.
+func (c *preparedCache) get(ctx context.Context, db preparer, str string) (*preparedEntry, bool, error) {
+	key := preparedKey{db: db, sql: str}
 
-// ScanJSON is a Scanner to unmarshal byte strings into T.
-func ScanJSON[T any](dest *T, str string) (Scanner, error) {
-	var data []byte
+	c.mu.Lock()
 
-	return Scanner{
-		SQL:   str,
-		Value: &data,
-		Map: func() error {
-			var d T
+	if elem, ok := c.items[key]; ok {
+		c.list.MoveToFront(elem)
+		c.stats.Hits++
 
-			if len(data) == 0 || bytes.Equal(data, null) {
-				*dest = d
+		entry := elem.Value.(*preparedEntry)
+		entry.refs++
 
-				return nil
-			}
+		c.mu.Unlock()
 
-			if err := json.Unmarshal(data, &d); err != nil {
-				*dest = d
+		return entry, true, nil
+	}
 
-				return err
-			}
+	c.stats.Misses++
+	c.mu.Unlock()
 
-			*dest = d
+	stmt, err := db.PrepareContext(ctx, str)
+	if err != nil {
+		return nil, false, err
+	}
 
-			return nil
-		},
-	}, nil
-}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-func defaultTemplate() *template.Template {
-	return template.New("").Funcs(template.FuncMap{
-		// ident is a stub function
-		ident: func(arg any) Raw {
-			return ""
-		},
-		"Raw": func(str string) Raw {
-			return Raw(str)
-		},
-		"Scan": func(value sql.Scanner, str string) (Scanner, error) {
-			if value == nil {
-				return Scanner{}, errors.New("invalid nil pointer")
-			}
+	if elem, ok := c.items[key]; ok {
+		c.list.MoveToFront(elem)
 
-			return Scanner{
-				SQL:   str,
-				Value: value,
-			}, nil
-		},
-		"ScanString":    Scan[string],
-		"ScanBytes":     Scan[[]byte],
-		"ScanInt":       Scan[int],
-		"ScanInt8":      Scan[int8],
-		"ScanInt16":     Scan[int16],
-		"ScanInt32":     Scan[int32],
-		"ScanInt64":     Scan[int64],
-		"ScanUint":      Scan[uint],
-		"ScanUint8":     Scan[uint8],
-		"ScanUint16":    Scan[uint16],
-		"ScanUint32":    Scan[uint32],
-		"ScanUint64":    Scan[uint64],
-		"ScanBool":      Scan[bool],
-		"ScanFloat32":   Scan[float32],
-		"ScanFloat64":   Scan[float64],
-		"ScanTime":      Scan[time.Time],
-		"ScanDuration":  Scan[time.Duration],
-		"ScanStringP":   Scan[*string],
-		"ScanBytesP":    Scan[*[]byte],
-		"ScanIntP":      Scan[*int],
-		"ScanInt8P":     Scan[*int8],
-		"ScanInt16P":    Scan[*int16],
-		"ScanInt32P":    Scan[*int32],
-		"ScanInt64P":    Scan[*int64],
-		"ScanUintP":     Scan[*uint],
-		"ScanUint8P":    Scan[*uint8],
-		"ScanUint16P":   Scan[*uint16],
-		"ScanUint32P":   Scan[*uint32],
-		"ScanUint64P":   Scan[*uint64],
-		"ScanBoolP":     Scan[*bool],
-		"ScanFloat32P":  Scan[*float32],
-		"ScanFloat64P":  Scan[*float64],
-		"ScanTimeP":     Scan[*time.Time],
-		"ScanDurationP": Scan[*time.Duration],
-	})
+		entry := elem.Value.(*preparedEntry)
+		entry.refs++
+
+		return entry, false, stmt.Close()
+	}
+
+	entry := &preparedEntry{key: key, stmt: stmt, refs: 1}
+
+	c.items[key] = c.list.PushFront(entry)
+
+	for c.list.Len() > c.size {
+		oldest := c.list.Remove(c.list.Back()).(*preparedEntry)
+
+		delete(c.items, oldest.key)
+
+		c.stats.Evictions++
+
+		_ = c.evict(oldest)
+	}
+
+	return entry, false, nil
 }
 
-// Runner groups the relevant data for each 'run' of a Statement.
-type Runner struct {
-	Context  context.Context
-	Template *template.Template
-	SQL      *SQL
-	Args     []any
-	Location string
+// stmtDB implements DB by executing a prepared statement. The query string is ignored.
+type stmtDB struct {
+	stmt *sql.Stmt
 }
 
-// Reset the Runner for the next run of a statement.
-func (r *Runner) Reset() {
-	r.Context = nil
-	r.SQL.Reset()
-	r.Args = r.Args[:0]
+func (s stmtDB) QueryContext(ctx context.Context, _ string, args ...any) (*sql.Rows, error) {
+	return s.stmt.QueryContext(ctx, args...)
 }
 
-// Exec creates and execute the sql query using ExecContext.
-func (r *Runner) Exec(db DB, param any) (sql.Result, error) {
-	if err := r.Template.Execute(r.SQL, param); err != nil {
-		return nil, err
-	}
+func (s stmtDB) QueryRowContext(ctx context.Context, _ string, args ...any) *sql.Row {
+	return s.stmt.QueryRowContext(ctx, args...)
+}
 
-	return db.ExecContext(r.Context, r.SQL.String(), r.Args...)
+func (s stmtDB) ExecContext(ctx context.Context, _ string, args ...any) (sql.Result, error) {
+	return s.stmt.ExecContext(ctx, args...)
 }
 
-// Query creates and execute the sql query using QueryContext.
-func (r *Runner) Query(db DB, param any) (*sql.Rows, error) {
-	if err := r.Template.Execute(r.SQL, param); err != nil {
-		return nil, err
-	}
+// Validate is executed after rendering to validate the sql against the database (e.g. using EXPLAIN).
+// Errors abort the execution. The most recently validated sql strings are remembered and not validated again
+// (see validatedSize). Together with Render this can be used as a dry-run.
+type Validate func(ctx context.Context, sql string) error
 
-	return db.QueryContext(r.Context, r.SQL.String(), r.Args...)
+// Configure implements the Option interface.
+func (v Validate) Configure(config *Config) {
+	config.Validate = v
 }
 
-// Query creates and execute the sql query using QueryRow.
-func (r *Runner) QueryRow(db DB, param any) (*sql.Row, error) {
-	if err := r.Template.Execute(r.SQL, param); err != nil {
-		return nil, err
-	}
+// validatedSize is the number of validated sql strings remembered by a statement.
+// Dynamic sql (e.g. lists of varying length) produces many different strings, so the least recently used are forgotten.
+const validatedSize = 1024
 
-	return db.QueryRowContext(r.Context, r.SQL.String(), r.Args...), nil
+// validatedCache is a least recently used set of validated sql strings.
+type validatedCache struct {
+	mu    sync.Mutex
+	list  *list.List
+	items map[string]*list.Element
 }
 
-// Stmt creates a type-safe Statement using variadic options.
-// Invalid templates panic.
-func Stmt[Param any](opts ...Option) *Statement[Param] {
-	_, file, line, _ := runtime.Caller(1)
+func newValidatedCache() *validatedCache {
+	return &validatedCache{
+		list:  list.New(),
+		items: map[string]*list.Element{},
+	}
+}
 
-	location := fmt.Sprintf("%s:%d", file, line)
+// contains reports whether str was validated and marks it as recently used.
+func (c *validatedCache) contains(str string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	config := &Config{
-		Placeholder: "?",
+	elem, ok := c.items[str]
+	if ok {
+		c.list.MoveToFront(elem)
 	}
 
-	for _, opt := range opts {
-		opt.Configure(config)
-	}
+	return ok
+}
 
-	var (
-		tpl = defaultTemplate().Funcs(template.FuncMap{
-			"Dest": func() any {
-				return nil
-			},
-		})
-		err error
-	)
+// add remembers str and forgets the least recently used strings beyond validatedSize.
+func (c *validatedCache) add(str string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	for _, to := range config.TemplateOptions {
-		tpl, err = to(tpl)
-		if err != nil {
-			panic(fmt.Errorf("location: [%s]: %w", location, err))
-		}
+	if elem, ok := c.items[str]; ok {
+		c.list.MoveToFront(elem)
+
+		return
 	}
 
-	if err = templatecheck.CheckText(tpl, *new(Param)); err != nil {
-		panic(fmt.Errorf("location: [%s]: %w", location, err))
+	c.items[str] = c.list.PushFront(str)
+
+	for c.list.Len() > validatedSize {
+		delete(c.items, c.list.Remove(c.list.Back()).(string))
 	}
+}
 
-	escape(tpl)
+// clear forgets all strings.
+func (c *validatedCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	positional := strings.Contains(string(config.Placeholder), "%d")
-	placeholder := string(config.Placeholder)
+	c.list.Init()
+	clear(c.items)
+}
 
-	return &Statement[Param]{
-		start: config.Start,
-		end:   config.End,
-		pool: &sync.Pool{
-			New: func() any {
-				t, err := tpl.Clone()
-				if err != nil {
-					panic(fmt.Errorf("location: [%s]: %w", location, err))
-				}
+// ValidateParam is executed before rendering to validate the param, e.g. to reject empty slices used in 'IN' clauses.
+// Errors abort the execution and are wrapped with the location of the statement.
+type ValidateParam func(param any) error
 
-				runner := &Runner{
-					Template: t,
-					SQL:      &SQL{},
-					Location: location,
-				}
+// Configure implements the Option interface.
+func (vp ValidateParam) Configure(config *Config) {
+	config.ValidateParam = vp
+}
 
-				t.Funcs(template.FuncMap{
-					ident: func(arg any) Raw {
-						switch a := arg.(type) {
-						case Raw:
-							return a
-						default:
-							runner.Args = append(runner.Args, arg)
+// Rewrite transforms the rendered sql and args just before the execution, e.g. to add index hints or to rewrite schema
+// prefixes for multi-tenancy. It receives a copy of the args (see UnsafeReuseArgs) and does not affect Render.
+// Multiple Rewrite options are chained in order.
+type Rewrite func(ctx context.Context, sql string, args []any) (string, []any, error)
 
-							if positional {
-								return Raw(fmt.Sprintf(placeholder, len(runner.Args)))
-							}
+// Configure implements the Option interface.
+func (rw Rewrite) Configure(config *Config) {
+	config.Rewrite = append(config.Rewrite, rw)
+}
 
-							return Raw(placeholder)
-						}
-					},
-				})
+// Schema resolves the schema of the current tenant from the context. It is used by the template function 'Table',
+// e.g. '{{ Table "users" }}' is rendered as '"tenant_42"."users"'. An empty schema leaves the table unqualified.
+type Schema func(ctx context.Context) string
 
-				return runner
-			},
-		},
-	}
+// Configure implements the Option interface.
+func (s Schema) Configure(config *Config) {
+	config.Schema = s
 }
 
-// Statements is a Runner pool and a type-safe sql executor.
-type Statement[Param any] struct {
-	start func(runner *Runner)
-	end   func(err error, runner *Runner)
-	pool  *sync.Pool
+// PrependArgs returns args from the context, that are bound before the args of the template, e.g. a tenant id
+// for row-level security using 'set_config'. Positional placeholders of the template are numbered after them,
+// so the template can reference the prepended args explicitly, e.g. '$1'.
+type PrependArgs func(ctx context.Context) []any
+
+// Configure implements the Option interface.
+func (pa PrependArgs) Configure(config *Config) {
+	config.PrependArgs = pa
 }
 
-// Get a Runner from the pool and execute the start option.
-func (s *Statement[Param]) Get(ctx context.Context) *Runner {
-	runner := s.pool.Get().(*Runner)
+// MapRow is executed with a pointer to Dest after each row is mapped, e.g. to compute fields or to validate the row.
+// Errors abort the scan and are wrapped with the location of the statement.
+type MapRow func(dest any) error
 
-	runner.Context = ctx
+// Configure implements the Option interface.
+func (mr MapRow) Configure(config *Config) {
+	config.MapRow = mr
+}
 
-	if s.start != nil {
-		s.start(runner)
-	}
+// ArrayArg wraps a slice for the binding as a Postgres array by the template function 'Any', e.g. using pq.Array.
+// Without ArrayArg, the slice is bound directly, as supported by pgx. 'id {{ Any .IDs }}' is rendered as
+// 'id = ANY($1)' for Postgres and falls back to 'id IN ($1, $2)' for other dialects.
+type ArrayArg func(list any) any
 
-	return runner
+// Configure implements the Option interface.
+func (aa ArrayArg) Configure(config *Config) {
+	config.ArrayArg = aa
 }
 
-// Put a Runner into the pool and execute the end option.
-func (s *Statement[Param]) Put(err error, runner *Runner) {
-	if s.end != nil {
-		s.end(err, runner)
-	}
+// Comment returns key-value pairs from the context, that are appended to the sql as sqlcommenter comment,
+// e.g. "/*route='%2Fusers'*/". Keys and values are url-encoded and sorted by key.
+// The comment is appended after validation and is part of the key of the Prepare cache.
+type Comment func(ctx context.Context) map[string]string
 
-	runner.Reset()
+// Configure implements the Option interface.
+func (c Comment) Configure(config *Config) {
+	config.Comment = c
+}
 
-	s.pool.Put(runner)
+// PreserveWhitespace disables the normalization of whitespace in the rendered sql.
+// The output of the template is used verbatim.
+type PreserveWhitespace bool
+
+// Configure implements the Option interface.
+func (pw PreserveWhitespace) Configure(config *Config) {
+	config.Whitespace = pw
 }
 
-// Exec takes a runner and executes it.
-func (s *Statement[Param]) Exec(ctx context.Context, db DB, param Param) (result sql.Result, err error) {
-	runner := s.Get(ctx)
+// MaxSQLSize is the maximum size of the rendered sql in bytes.
+// Rendering fails, if the sql exceeds the size, e.g. because of an unbounded 'In' expansion.
+type MaxSQLSize int
 
-	defer func() {
-		if r := recover(); r != nil {
-			err = errors.Join(err, toErr(r))
-		}
+// Configure implements the Option interface.
+func (m MaxSQLSize) Configure(config *Config) {
+	config.MaxSQLSize = m
+}
 
-		s.Put(err, runner)
-	}()
+// RequireAllFields checks, when a QueryStatement is created, that each exported field of a struct Dest is used
+// in the templates (e.g. '{{ ScanString Dest.Title "title" }}'). Fields can be opted out using the tag 'sqlt:"optional"'.
+// The check is skipped, if Dest is used as a whole (e.g. 'ScanAll Dest "..."' or in a variable).
+type RequireAllFields bool
 
-	return runner.Exec(db, param)
+// Configure implements the Option interface.
+func (raf RequireAllFields) Configure(config *Config) {
+	config.RequireAllFields = raf
 }
 
-// QueryRow takes a runner and queries a row.
-func (s *Statement[Param]) QueryRow(ctx context.Context, db DB, param Param) (row *sql.Row, err error) {
-	runner := s.Get(ctx)
+// Timeout limits the duration of each run using context.WithTimeout. An earlier deadline of the context is kept.
+// The context is cancelled, when the Runner is put back into the pool. Statement.Query and Statement.QueryRow
+// return their results before, so they reject a Timeout (use QueryFunc or Scalar instead).
+type Timeout time.Duration
 
-	defer func() {
-		if r := recover(); r != nil {
-			err = errors.Join(err, toErr(r))
-		}
+// Configure implements the Option interface.
+func (t Timeout) Configure(config *Config) {
+	config.Timeout = t
+}
 
-		s.Put(err, runner)
-	}()
+// MarshalArgs converts arguments, that are not supported by database/sql, using encoding.TextMarshaler
+// or json.Marshaler before they are bound. driver.Valuer arguments are not converted.
+type MarshalArgs bool
 
-	row, err = runner.QueryRow(db, param)
-	if err != nil {
-		return row, err
-	}
+// Configure implements the Option interface.
+func (ma MarshalArgs) Configure(config *Config) {
+	config.MarshalArgs = ma
+}
 
-	return row, row.Err()
+// AssertDeterministic renders each template twice and returns an error, if the sql or the args differ, e.g. because
+// of a function like 'now'. Results keyed by the rendered Expression (see Expression.Key) are only valid for
+// deterministic templates. It is intended for development and tests.
+type AssertDeterministic bool
+
+// Configure implements the Option interface.
+func (ad AssertDeterministic) Configure(config *Config) {
+	config.Deterministic = ad
 }
 
-// Query takes a runner and queries rows.
-func (s *Statement[Param]) Query(ctx context.Context, db DB, param Param) (rows *sql.Rows, err error) {
-	runner := s.Get(ctx)
+// IntervalArgs converts time.Duration arguments, which are bound as nanoseconds by default. The 'Postgres' dialect
+// binds intervals like '90 minutes' rounded to microseconds, other dialects bind the seconds as float64.
+type IntervalArgs bool
 
-	defer func() {
-		if r := recover(); r != nil {
-			err = errors.Join(err, toErr(r))
-		}
+// Configure implements the Option interface.
+func (ia IntervalArgs) Configure(config *Config) {
+	config.IntervalArgs = ia
+}
 
-		s.Put(err, runner)
-	}()
+// UnsafeReuseArgs passes the pooled args of the Runner to the Rewrite options without copying them, to avoid an allocation
+// per run. Modifications of the args are visible in Runner.Args and the Rewrite options must not retain them.
+type UnsafeReuseArgs bool
 
-	return runner.Query(db, param)
+// Configure implements the Option interface.
+func (ura UnsafeReuseArgs) Configure(config *Config) {
+	config.UnsafeReuseArgs = ura
 }
 
-// QueryRunner groups the relevant data for each 'run' of a QueryStatement.
-type QueryRunner[Dest any] struct {
-	Runner  *Runner
-	Dest    *Dest
-	Values  []any
-	Mappers []func() error
+// FoldCase matches columns to fields case-insensitively in ScanAll and Two, e.g. for Postgres, which folds unquoted
+// aliases like 'userId' to lower case.
+type FoldCase bool
+
+// Configure implements the Option interface.
+func (fc FoldCase) Configure(config *Config) {
+	config.FoldCase = fc
 }
 
-// Reset the QueryRunner for the next run of a statement.
-func (qr *QueryRunner[Dest]) Reset() {
-	qr.Runner.Reset()
-	qr.Values = qr.Values[:0]
-	qr.Mappers = qr.Mappers[:0]
+// ReadOnly declares the queries of a statement as read-only, so that ReadWriteSplit routes them to the replica.
+type ReadOnly bool
+
+// Configure implements the Option interface.
+func (ro ReadOnly) Configure(config *Config) {
+	config.ReadOnly = ro
 }
 
-// QueryStmt creates a type-safe QueryStatement using variadic options.
-// Define the mapping of a column to a struct field here using the Scan functions.
-// Invalid templates panic.
-func QueryStmt[Param, Dest any](opts ...Option) *QueryStatement[Param, Dest] {
-	_, file, line, _ := runtime.Caller(1)
+// CompactParens removes the spaces after '(' and before ')' outside of string literals,
+// e.g. 'IN ( ?, ? )' is written as 'IN (?, ?)'. It has no effect, if PreserveWhitespace is set.
+type CompactParens bool
 
-	location := fmt.Sprintf("%s:%d", file, line)
+// Configure implements the Option interface.
+func (cp CompactParens) Configure(config *Config) {
+	config.CompactParens = cp
+}
 
-	config := &Config{
-		Placeholder: "?",
-	}
+// Tracer starts a Span for each run of a statement, e.g. using an adapter for OpenTelemetry.
+// The Span is named after the location of the statement and ended, when the Runner is put back into the pool.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
 
-	for _, opt := range opts {
-		opt.Configure(config)
+// Span is started by a Tracer.
+// The attributes 'db.statement', 'db.args', 'sqlt.attempt' and 'sqlt.prepared' are set before the Span is ended.
+type Span interface {
+	SetAttribute(key string, value any)
+	End(err error)
+}
+
+// Trace sets the Tracer.
+func Trace(tracer Tracer) Config {
+	return Config{
+		Tracer: tracer,
 	}
+}
 
-	var (
-		tpl = defaultTemplate().Funcs(template.FuncMap{
-			"Dest": func() *Dest {
-				return new(Dest)
-			},
-		})
-		err error
-	)
+// SlogLogger logs each run of a statement with the location, sql, duration, number of args,
+// prepared statement cache hit and error. The values of the args are not logged (see SlogLoggerWithArgs).
+// It wraps the Start and End options configured before it.
+func SlogLogger(logger *slog.Logger, level slog.Level) Option {
+	return slogLogger{logger: logger, level: level}
+}
 
-	destType := reflect.TypeFor[Dest]().Name()
+// SlogLoggerWithArgs is like SlogLogger, but includes the values of the args.
+func SlogLoggerWithArgs(logger *slog.Logger, level slog.Level) Option {
+	return slogLogger{logger: logger, level: level, withArgs: true}
+}
 
-	if goodName(destType) {
-		tpl.Funcs(template.FuncMap{
-			destType: func() *Dest {
-				return new(Dest)
-			},
-		})
+type slogLogger struct {
+	logger   *slog.Logger
+	level    slog.Level
+	withArgs bool
+}
+
+// Configure implements the Option interface.
+func (sl slogLogger) Configure(config *Config) {
+	start, end := config.Start, config.End
+
+	config.Start = func(runner *Runner) {
+		runner.started = time.Now()
+
+		if start != nil {
+			start(runner)
+		}
 	}
 
-	for _, to := range config.TemplateOptions {
-		tpl, err = to(tpl)
+	config.End = func(err error, runner *Runner) {
+		if end != nil {
+			end(err, runner)
+		}
+
+		if !sl.logger.Enabled(runner.Context, sl.level) {
+			return
+		}
+
+		attrs := []slog.Attr{
+			slog.String("location", runner.Location),
+			slog.String("sql", runner.SQL.String()),
+			slog.Duration("duration", time.Since(runner.started)),
+			slog.Int("args", len(runner.Args)),
+			slog.Bool("prepared", runner.preparedHit),
+		}
+
+		if sl.withArgs {
+			attrs = append(attrs, slog.Any("values", runner.Args))
+		}
+
 		if err != nil {
-			panic(fmt.Errorf("location: [%s]: %w", location, err))
+			attrs = append(attrs, slog.Any("error", err))
 		}
-	}
 
-	if err = templatecheck.CheckText(tpl, *new(Param)); err != nil {
-		panic(fmt.Errorf("location: [%s]: %w", location, err))
+		sl.logger.LogAttrs(runner.Context, sl.level, "sqlt", attrs...)
 	}
+}
 
-	escape(tpl)
+// Registry collects statements, e.g. to check them in smoke tests.
+// Statements are registered by their location. Statements built at the same location (e.g. in a loop or a helper)
+// are all kept; the second and following are registered as 'location#2', 'location#3' and so on.
+type Registry struct {
+	mu         sync.Mutex
+	statements map[string]renderer
+}
 
-	positional := strings.Contains(string(config.Placeholder), "%d")
-	placeholder := string(config.Placeholder)
+// renderer is implemented by *Statement and *QueryStatement.
+type renderer interface {
+	renderZero(ctx context.Context) error
+}
 
-	return &QueryStatement[Param, Dest]{
-		start: config.Start,
-		end:   config.End,
-		pool: &sync.Pool{
-			New: func() any {
-				t, err := tpl.Clone()
-				if err != nil {
-					panic(fmt.Errorf("clone: location: [%s]: %w", location, err))
-				}
+// Configure implements the Option interface.
+func (r *Registry) Configure(config *Config) {
+	config.Registry = r
+}
 
-				runner := &QueryRunner[Dest]{
-					Runner: &Runner{
-						Template: t,
-						SQL:      &SQL{},
-						Location: location,
-					},
-					Dest: new(Dest),
-				}
+func (r *Registry) register(location string, stmt renderer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-				if goodName(destType) {
-					t.Funcs(template.FuncMap{
-						destType: func() *Dest {
-							return runner.Dest
-						},
-					})
+	if r.statements == nil {
+		r.statements = map[string]renderer{}
+	}
+
+	key := location
+
+	for i := 2; r.statements[key] != nil; i++ {
+		key = fmt.Sprintf("%s#%d", location, i)
+	}
+
+	r.statements[key] = stmt
+}
+
+// RenderAll renders each registered statement with the zero value of its Param.
+// Errors and panics during rendering are returned by the location of the statement.
+func (r *Registry) RenderAll(ctx context.Context) map[string]error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	errs := map[string]error{}
+
+	for location, stmt := range r.statements {
+		if err := stmt.renderZero(ctx); err != nil {
+			errs[location] = err
+		}
+	}
+
+	return errs
+}
+
+// Placeholder can be static or positional using a go-formatted string ('%d').
+type Placeholder string
+
+// Configure implements the Option interface.
+func (p Placeholder) Configure(config *Config) {
+	config.Placeholder = p
+	config.Named = ""
+	config.placeholderSet = true
+}
+
+// Dollar is a positional placeholder.
+func Dollar() Placeholder {
+	return "$%d"
+}
+
+// Colon is a positional placeholder.
+func Colon() Placeholder {
+	return ":%d"
+}
+
+// AtP is a positional placeholder.
+func AtP() Placeholder {
+	return "@p%d"
+}
+
+// Question is a static placeholder.
+func Question() Placeholder {
+	return "?"
+}
+
+// Named is the prefix of named placeholders. It overrides the Placeholder option.
+// The arguments are passed as sql.NamedArg using the names 'arg1', 'arg2', etc.
+type Named string
+
+// Configure implements the Option interface.
+func (n Named) Configure(config *Config) {
+	config.Named = n
+	config.placeholderSet = true
+}
+
+// NamedPlaceholder emits named placeholders like ':arg1' for the prefix ':'.
+func NamedPlaceholder(prefix string) Named {
+	return Named(prefix)
+}
+
+// Dialect is the name of the database dialect. It is used by dialect-aware template functions.
+// The defaults of a registered dialect (see RegisterDialect) are applied, so following options can override them.
+// A Placeholder or Named option set explicitly is never overridden by the defaults, regardless of the order.
+type Dialect string
+
+// Configure implements the Option interface.
+func (d Dialect) Configure(config *Config) {
+	if defaults, ok := lookupDialect(d); ok {
+		defaults.Dialect = ""
+
+		if config.placeholderSet {
+			defaults.Placeholder, defaults.Named = "", ""
+		}
+
+		set := config.placeholderSet
+
+		defaults.Configure(config)
+
+		config.placeholderSet = set
+	}
+
+	config.Dialect = d
+}
+
+var (
+	dialectsMu sync.RWMutex
+	// dialects are the defaults of the registered dialects.
+	dialects = map[Dialect]Config{
+		"Postgres":  {Placeholder: Dollar()},
+		"Sqlite":    {Placeholder: Question()},
+		"MySQL":     {Placeholder: Question()},
+		"SQLServer": {Placeholder: AtP()},
+	}
+)
+
+// RegisterDialect registers the defaults of a dialect, e.g. the placeholder for 'ClickHouse'.
+// The defaults are applied by the Dialect and DialectFunc options.
+func RegisterDialect(name string, config Config) {
+	dialectsMu.Lock()
+	defer dialectsMu.Unlock()
+
+	config.Dialect = Dialect(name)
+
+	dialects[Dialect(name)] = config
+}
+
+// lookupDialect returns the registered defaults of a dialect.
+func lookupDialect(name Dialect) (Config, bool) {
+	dialectsMu.RLock()
+	defer dialectsMu.RUnlock()
+
+	config, ok := dialects[name]
+
+	return config, ok
+}
+
+// Postgres sets the dialect to 'Postgres', which uses positional placeholders ('$%d') by default.
+func Postgres() Config {
+	return Config{
+		Dialect: "Postgres",
+	}
+}
+
+// Sqlite sets the dialect to 'Sqlite', which uses static placeholders ('?') by default.
+func Sqlite() Config {
+	return Config{
+		Dialect: "Sqlite",
+	}
+}
+
+// MySQL sets the dialect to 'MySQL', which uses static placeholders ('?') by default.
+func MySQL() Config {
+	return Config{
+		Dialect: "MySQL",
+	}
+}
+
+// SQLServer sets the dialect to 'SQLServer', which uses positional placeholders ('@p%d') by default.
+func SQLServer() Config {
+	return Config{
+		Dialect: "SQLServer",
+	}
+}
+
+// DialectFunc returns the dialect for the context of a run, e.g. to route reads to a replica using a different engine.
+// It overrides the Dialect option and applies the defaults of registered dialects. Empty names keep the configured dialect.
+// The 'Dialect' template function returns the dynamic dialect.
+type DialectFunc func(ctx context.Context) string
+
+// Configure implements the Option interface.
+func (df DialectFunc) Configure(config *Config) {
+	config.DialectFunc = df
+}
+
+// TruncateMode controls the sql emitted by the 'Truncate' template function.
+type TruncateMode int
+
+const (
+	// TruncateAuto uses 'TRUNCATE TABLE' where the dialect supports it and 'DELETE FROM' otherwise.
+	TruncateAuto TruncateMode = iota
+	// TruncateTable forces 'TRUNCATE TABLE'.
+	TruncateTable
+	// TruncateDelete forces 'DELETE FROM'.
+	TruncateDelete
+)
+
+// Configure implements the Option interface.
+func (tm TruncateMode) Configure(config *Config) {
+	config.Truncate = tm
+}
+
+// TemplateOption can be used to configure the template of a statement.
+type TemplateOption func(tpl *template.Template) (*template.Template, error)
+
+// Configure implements the Option interface.
+func (to TemplateOption) Configure(config *Config) {
+	config.TemplateOptions = append(config.TemplateOptions, to)
+}
+
+// New is equivalent to the method from text/template.
+func New(name string) TemplateOption {
+	return func(tpl *template.Template) (*template.Template, error) {
+		return tpl.New(name), nil
+	}
+}
+
+// Parse is equivalent to the method from text/template.
+func Parse(text string) TemplateOption {
+	return func(tpl *template.Template) (*template.Template, error) {
+		tpl, err := tpl.Parse(text)
+		if err != nil {
+			return nil, err
+		}
+
+		return tpl, addSource(tpl, tpl.Name(), text)
+	}
+}
+
+// ParseFS is equivalent to the method from text/template.
+func ParseFS(fsys fs.FS, patterns ...string) TemplateOption {
+	return func(tpl *template.Template) (*template.Template, error) {
+		tpl, err := tpl.ParseFS(fsys, patterns...)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pattern := range patterns {
+			filenames, err := fs.Glob(fsys, pattern)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, filename := range filenames {
+				data, err := fs.ReadFile(fsys, filename)
+				if err != nil {
+					return nil, err
 				}
 
-				t.Funcs(template.FuncMap{
-					"Dest": func() *Dest {
-						return runner.Dest
-					},
-					ident: func(arg any) Raw {
-						switch a := arg.(type) {
-						case Raw:
-							return a
-						case Scanner:
-							runner.Values = append(runner.Values, a.Value)
-							runner.Mappers = append(runner.Mappers, a.Map)
+				if err = addSource(tpl, path.Base(filename), string(data)); err != nil {
+					return nil, err
+				}
+			}
+		}
 
-							return Raw(a.SQL)
-						default:
-							runner.Runner.Args = append(runner.Runner.Args, arg)
+		return tpl, nil
+	}
+}
 
-							if positional {
-								return Raw(fmt.Sprintf(placeholder, len(runner.Runner.Args)))
-							}
+// ParseWithFuncs registers the functions before parsing the text, so the functions are always defined.
+func ParseWithFuncs(text string, fm template.FuncMap) Config {
+	return Config{
+		TemplateOptions: []TemplateOption{Funcs(fm), Parse(text)},
+	}
+}
 
-							return Raw(placeholder)
-						}
-					},
-				})
+// FromTemplate uses the parse trees of the pre-built template t and its associated templates. t is executed by the
+// statement. The funcs of t are not accessible and must be registered using Funcs, if they are not provided by sqlt.
+// Like parsed templates, t is checked and escaped, when the statement is created.
+func FromTemplate(t *template.Template) Config {
+	return Config{
+		TemplateOptions: []TemplateOption{
+			func(tpl *template.Template) (*template.Template, error) {
+				for _, at := range t.Templates() {
+					if at.Tree == nil {
+						continue
+					}
+
+					if _, err := tpl.AddParseTree(at.Name(), at.Tree.Copy()); err != nil {
+						return nil, err
+					}
+				}
 
-				return runner
+				if tpl = tpl.Lookup(t.Name()); tpl == nil {
+					return nil, fmt.Errorf("template '%s' not found", t.Name())
+				}
+
+				return tpl, nil
 			},
 		},
 	}
 }
 
-// QueryStatement is a QueryRunner pool and a type-safe sql query executor.
-type QueryStatement[Param, Dest any] struct {
-	start func(runner *Runner)
-	end   func(err error, runner *Runner)
-	pool  *sync.Pool
+// ParseReader reads all bytes of r and parses them as the template with the given name, e.g. for a single embedded file.
+func ParseReader(name string, r io.Reader) TemplateOption {
+	return func(tpl *template.Template) (*template.Template, error) {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", name, err)
+		}
+
+		tpl, err = tpl.New(name).Parse(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", name, err)
+		}
+
+		return tpl, addSource(tpl, name, string(data))
+	}
+}
+
+// ParseFiles is equivalent to the method from text/template.
+func ParseFiles(filenames ...string) TemplateOption {
+	return func(tpl *template.Template) (*template.Template, error) {
+		tpl, err := tpl.ParseFiles(filenames...)
+		if err != nil {
+			return nil, err
+		}
+
+		return tpl, addFileSources(tpl, filenames)
+	}
+}
+
+// ParseGlob is equivalent to the method from text/template.
+func ParseGlob(pattern string) TemplateOption {
+	return func(tpl *template.Template) (*template.Template, error) {
+		tpl, err := tpl.ParseGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		filenames, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		return tpl, addFileSources(tpl, filenames)
+	}
+}
+
+// source is the prefix of the templates holding the source text of a parsed template (see TemplateError).
+var source = "___sqlt_source___"
+
+// addSource stores text as the source of the templates parsed with the name parseName.
+// The source is kept in an associated template, so that it is cloned together with the parsed templates.
+func addSource(tpl *template.Template, parseName, text string) error {
+	_, err := tpl.AddParseTree(source+parseName, &parse.Tree{
+		Name: source + parseName,
+		Root: &parse.ListNode{
+			NodeType: parse.NodeList,
+			Nodes:    []parse.Node{&parse.TextNode{NodeType: parse.NodeText, Text: []byte(text)}},
+		},
+	})
+
+	return err
+}
+
+// addFileSources stores the sources of the files parsed by ParseFiles or ParseGlob.
+func addFileSources(tpl *template.Template, filenames []string) error {
+	for _, filename := range filenames {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+
+		if err = addSource(tpl, filepath.Base(filename), string(data)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lookupSource returns the source text of the templates parsed with the name parseName.
+func lookupSource(tpl *template.Template, parseName string) (string, bool) {
+	t := tpl.Lookup(source + parseName)
+	if t == nil || t.Tree == nil || t.Tree.Root == nil || len(t.Tree.Root.Nodes) != 1 {
+		return "", false
+	}
+
+	text, ok := t.Tree.Root.Nodes[0].(*parse.TextNode)
+	if !ok {
+		return "", false
+	}
+
+	return string(text.Text), true
+}
+
+// Funcs is equivalent to the method from text/template.
+func Funcs(fm template.FuncMap) TemplateOption {
+	return func(tpl *template.Template) (*template.Template, error) {
+		return tpl.Funcs(fm), nil
+	}
+}
+
+// Include applies the TemplateOptions of config to the namespace of the statement, e.g. to share fragments defined
+// by '{{ define "columns" }}id, title{{ end }}' and their Funcs across independently built statements.
+// Text outside of define actions is ignored.
+func Include(config Config) TemplateOption {
+	return func(tpl *template.Template) (*template.Template, error) {
+		t := tpl.New(include)
+
+		for _, to := range config.TemplateOptions {
+			var err error
+
+			t, err = to(t)
+			if err != nil {
+				return nil, fmt.Errorf("include: %w", err)
+			}
+		}
+
+		return tpl, nil
+	}
 }
 
-// Get a QueryRunner from the pool and execute the start option.
-func (qs *QueryStatement[Param, Dest]) Get(ctx context.Context) *QueryRunner[Dest] {
-	runner := qs.pool.Get().(*QueryRunner[Dest])
+// ScanFuncs registers custom Scanners for domain types as template functions with the prefix 'Scan'.
+// For example, the key 'Money' is available as 'ScanMoney' in the templates.
+// Like the builtin Scanners, dest receives a pointer to addressable fields (e.g. 'ScanMoney Dest.Price "price"').
+func ScanFuncs(scanners map[string]func(dest any, str string, args ...any) (Scanner, error)) TemplateOption {
+	return func(tpl *template.Template) (*template.Template, error) {
+		fm := template.FuncMap{}
+
+		for name, scanner := range scanners {
+			if !goodName("Scan" + name) {
+				return nil, fmt.Errorf("invalid scanner name '%s'", name)
+			}
+
+			fm["Scan"+name] = func(dest reflect.Value, str string, args ...any) (Scanner, error) {
+				if !dest.IsValid() {
+					return Scanner{}, errors.New("invalid nil destination")
+				}
+
+				if dest.CanAddr() {
+					return scanner(dest.Addr().Interface(), str, args...)
+				}
+
+				return scanner(dest.Interface(), str, args...)
+			}
+		}
+
+		return tpl.Funcs(fm), nil
+	}
+}
+
+// TextScanner returns a Scanner for ScanFuncs, that scans the column as text and converts it using parse,
+// e.g. '"Decimal": sqlt.TextScanner(decimal.NewFromString)' for exact decimals of money columns.
+// dest must be *T or **T. NULL values result in the zero value of T or nil.
+func TextScanner[T any](parse func(text string) (T, error)) func(dest any, str string, args ...any) (Scanner, error) {
+	return func(dest any, str string, _ ...any) (Scanner, error) {
+		var data sql.NullString
+
+		switch d := dest.(type) {
+		case *T:
+			return Scanner{
+				SQL:   str,
+				Value: &data,
+				Map: func() error {
+					if !data.Valid {
+						*d = *new(T)
+
+						return nil
+					}
+
+					t, err := parse(data.String)
+					if err != nil {
+						return err
+					}
+
+					*d = t
+
+					return nil
+				},
+			}, nil
+		case **T:
+			return Scanner{
+				SQL:   str,
+				Value: &data,
+				Map: func() error {
+					if !data.Valid {
+						*d = nil
+
+						return nil
+					}
+
+					t, err := parse(data.String)
+					if err != nil {
+						return err
+					}
+
+					*d = &t
+
+					return nil
+				},
+			}, nil
+		default:
+			return Scanner{}, fmt.Errorf("invalid type %T: expected *%s", dest, reflect.TypeFor[T]())
+		}
+	}
+}
+
+// EnumScanner returns a Scanner for ScanFuncs, that maps text columns to typed constants using values,
+// e.g. '"Status": sqlt.EnumScanner(map[string]Status{"active": Active, "inactive": Inactive})'.
+// Unknown values result in an error. Like TextScanner, dest must be *T or **T.
+func EnumScanner[T any](values map[string]T) func(dest any, str string, args ...any) (Scanner, error) {
+	return TextScanner(func(text string) (T, error) {
+		value, ok := values[text]
+		if !ok {
+			return value, fmt.Errorf("unknown enum value '%s'", text)
+		}
+
+		return value, nil
+	})
+}
+
+// MissingKeyInvalid is equivalent to the method 'Option("missingkey=invalid")' from text/template.
+func MissingKeyInvalid() TemplateOption {
+	return func(tpl *template.Template) (*template.Template, error) {
+		return tpl.Option("missingkey=invalid"), nil
+	}
+}
+
+// MissingKeyZero is equivalent to the method 'Option("missingkey=zero")' from text/template.
+func MissingKeyZero() TemplateOption {
+	return func(tpl *template.Template) (*template.Template, error) {
+		return tpl.Option("missingkey=zero"), nil
+	}
+}
+
+// MissingKeyError is equivalent to the method 'Option("missingkey=error")' from text/template.
+func MissingKeyError() TemplateOption {
+	return func(tpl *template.Template) (*template.Template, error) {
+		return tpl.Option("missingkey=error"), nil
+	}
+}
+
+// Lookup is equivalent to the method from text/template.
+func Lookup(name string) TemplateOption {
+	return func(tpl *template.Template) (*template.Template, error) {
+		tpl = tpl.Lookup(name)
+		if tpl == nil {
+			return nil, fmt.Errorf("template '%s' not found", name)
+		}
+
+		return tpl, nil
+	}
+}
+
+// ContextKey is the key of context values, that are available in the templates using the function 'Ctx',
+// e.g. '{{ if Ctx "feature" }}...{{ end }}' for 'context.WithValue(ctx, sqlt.ContextKey("feature"), true)'.
+// Other context values are not accessible. Like the param, written values are bound as args.
+type ContextKey string
+
+// Raw is used to write strings directly into the sql output.
+// It should be used carefully.
+type Raw string
+
+// A RunnerFunc is returned by template functions that need access to the executing Runner.
+// It is resolved when the output of the template action is written.
+type RunnerFunc func(runner *Runner) (Raw, error)
+
+// Where accumulates predicates and emits them joined by 'AND' with a leading 'WHERE'.
+// If there are no predicates, nothing is emitted:
+//
+//	{{ $w := Where }}{{ if .Name }}{{ $w.And "name =" .Name }}{{ end }}{{ $w.SQL }}
+type Where struct {
+	predicates []predicate
+}
+
+type predicate struct {
+	sql  string
+	args []any
+}
+
+// And adds a predicate. Each arg is bound as a comma-separated placeholder after the sql, e.g. 'name = ?'.
+// The placeholders are bound, when the output of SQL is written. And emits nothing.
+func (w *Where) And(sql string, args ...any) Raw {
+	w.predicates = append(w.predicates, predicate{sql: strings.TrimSpace(sql), args: args})
+
+	return ""
+}
+
+// SQL emits the predicates.
+func (w *Where) SQL() RunnerFunc {
+	return func(runner *Runner) (Raw, error) {
+		if len(w.predicates) == 0 {
+			return "", nil
+		}
+
+		parts := make([]string, len(w.predicates))
+
+		for i, p := range w.predicates {
+			if len(p.args) == 0 {
+				parts[i] = p.sql
+
+				continue
+			}
+
+			placeholders := make([]string, len(p.args))
+
+			for j, arg := range p.args {
+				placeholders[j] = string(runner.Bind(arg))
+			}
+
+			parts[i] = p.sql + " " + strings.Join(placeholders, ", ")
+		}
+
+		return Raw("WHERE " + strings.Join(parts, " AND ")), nil
+	}
+}
+
+// A Scanner is used to map columns to struct fields.
+// Value should be a pointer to a struct field.
+type Scanner struct {
+	Value any
+	Map   func() error
+	SQL   string
+}
+
+// Scanners are multiple Scanners, whose sql is joined with ', '.
+type Scanners []Scanner
+
+// ScanAll creates a Scanner for each column of the comma-separated list.
+// Columns are matched to the fields of the struct dest by a 'sql' tag or by the field name, including the fields of
+// embedded structs. Aliases ('t.id AS id') and qualified names ('t.id') are matched by the last identifier.
+// Commas inside parentheses and quotes don't separate columns, so expressions need an alias ('COALESCE(a, b) AS x').
+// Nested struct fields are matched by dotted aliases, e.g. 'a.name AS Author.Name'.
+// In QueryStmt, calls on Dest with a constant list of columns are validated on construction.
+func ScanAll(dest any, columns string) (Scanners, error) {
+	return scanAll(dest, columns, false)
+}
+
+// scanAll creates the Scanners of ScanAll. If fold is true, the columns are matched case-insensitively.
+func scanAll(dest any, columns string, fold bool) (Scanners, error) {
+	value := reflect.ValueOf(dest)
+
+	if value.Kind() != reflect.Pointer || value.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("invalid type %T: expected pointer to struct", dest)
+	}
+
+	var scanners Scanners
+
+	for _, column := range splitColumns(columns) {
+		column = strings.TrimSpace(column)
+
+		field, ok := fieldByPath(value.Elem(), columnPath(column), fold)
+		if !ok {
+			field, ok = fieldByColumn(value.Elem(), columnName(column), fold)
+		}
+
+		if !ok {
+			return nil, fmt.Errorf("column '%s' has no matching field in type %s", column, value.Elem().Type())
+		}
+
+		scanners = append(scanners, Scanner{
+			SQL:   column,
+			Value: field.Addr().Interface(),
+		})
+	}
+
+	return scanners, nil
+}
+
+// splitColumns splits a list of columns at the commas outside of parentheses and quotes,
+// so that expressions like 'COALESCE(a, b) AS x' are kept as one column.
+func splitColumns(columns string) []string {
+	var (
+		parts []string
+		start int
+		depth int
+		quote byte
+	)
+
+	for i := range len(columns) {
+		c := columns[i]
+
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'', c == '"', c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, columns[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, columns[start:])
+}
+
+// columnPath returns the alias or the qualified name of a column.
+func columnPath(column string) string {
+	fields := strings.Fields(column)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return fields[len(fields)-1]
+}
+
+// columnName returns the alias or the unqualified name of a column.
+func columnName(column string) string {
+	fields := strings.Fields(column)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	name := fields[len(fields)-1]
+
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+
+	return name
+}
+
+// fieldByColumn returns the field of a struct with a matching 'sql' tag or name.
+// Fields of embedded structs are matched after the direct fields. If fold is true, the names are compared case-insensitively.
+func fieldByColumn(value reflect.Value, column string, fold bool) (reflect.Value, bool) {
+	equal := func(name string) bool {
+		return name == column || (fold && strings.EqualFold(name, column))
+	}
+
+	for i := range value.NumField() {
+		field := value.Type().Field(i)
+
+		if field.IsExported() && equal(field.Tag.Get("sql")) {
+			return value.Field(i), true
+		}
+	}
+
+	for i := range value.NumField() {
+		field := value.Type().Field(i)
+
+		if field.IsExported() && equal(field.Name) {
+			return value.Field(i), true
+		}
+	}
+
+	for i := range value.NumField() {
+		field := value.Type().Field(i)
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if f, ok := fieldByColumn(value.Field(i), column, fold); ok {
+				return f, true
+			}
+		}
+	}
+
+	return reflect.Value{}, false
+}
+
+// fieldByPath returns the field of a nested struct by a dotted path of columns (e.g. 'Author.Name').
+// Pointers are not followed, because Dest is reused for each row (see ScanFlag for optional sub-structs).
+func fieldByPath(value reflect.Value, path string, fold bool) (reflect.Value, bool) {
+	for _, part := range strings.Split(path, ".") {
+		if value.Kind() != reflect.Struct {
+			return reflect.Value{}, false
+		}
+
+		field, ok := fieldByColumn(value, part, fold)
+		if !ok {
+			return reflect.Value{}, false
+		}
+
+		value = field
+	}
+
+	return value, true
+}
+
+// Scan is a Scanner for values, that can be used directly with your sql driver.
+func Scan[T any](dest *T, str string) (Scanner, error) {
+	return Scanner{
+		SQL:   str,
+		Value: dest,
+	}, nil
+}
+
+var null = []byte("null")
+
+// ScanNull is a Scanner for sql.Null fields, e.g. sql.Null[string].
+// In templates, 'ScanNull' accepts any addressable sql.Scanner.
+func ScanNull[T any](dest *sql.Null[T], str string) (Scanner, error) {
+	return Scan(dest, str)
+}
+
+// scanScanner is a Scanner for (addressable) values implementing sql.Scanner.
+func scanScanner(value sql.Scanner, str string) (Scanner, error) {
+	if value == nil {
+		return Scanner{}, errors.New("invalid nil pointer")
+	}
+
+	return Scanner{
+		SQL:   str,
+		Value: value,
+	}, nil
+}
+
+// ScanJSON is a Scanner to unmarshal byte strings into T.
+func ScanJSON[T any](dest *T, str string) (Scanner, error) {
+	var data []byte
+
+	return Scanner{
+		SQL:   str,
+		Value: &data,
+		Map: func() error {
+			var d T
+
+			if len(data) == 0 || bytes.Equal(data, null) {
+				*dest = d
+
+				return nil
+			}
+
+			if err := json.Unmarshal(data, &d); err != nil {
+				*dest = d
+
+				return err
+			}
+
+			*dest = d
+
+			return nil
+		},
+	}, nil
+}
+
+// ScanJSONStrict is a Scanner to unmarshal byte strings into T.
+// Unlike ScanJSON, unknown fields in the data result in an error.
+func ScanJSONStrict[T any](dest *T, str string) (Scanner, error) {
+	var data []byte
+
+	return Scanner{
+		SQL:   str,
+		Value: &data,
+		Map: func() error {
+			var d T
+
+			if len(data) == 0 || bytes.Equal(data, null) {
+				*dest = d
+
+				return nil
+			}
+
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.DisallowUnknownFields()
+
+			if err := decoder.Decode(&d); err != nil {
+				*dest = d
+
+				return err
+			}
+
+			*dest = d
+
+			return nil
+		},
+	}, nil
+}
+
+// scanJSONValue is a Scanner to unmarshal byte strings into the addressable value dest of any type.
+// It is used by the template functions 'ScanJSON' and 'ScanJSONStrict'.
+func scanJSONValue(dest reflect.Value, str string, strict bool) (Scanner, error) {
+	if dest.Kind() == reflect.Pointer && !dest.CanAddr() {
+		dest = dest.Elem()
+	}
+
+	if !dest.CanSet() {
+		return Scanner{}, fmt.Errorf("invalid destination: %s is not addressable", dest.Type())
+	}
+
+	var data []byte
+
+	return Scanner{
+		SQL:   str,
+		Value: &data,
+		Map: func() error {
+			value := reflect.New(dest.Type())
+
+			if len(data) == 0 || bytes.Equal(data, null) {
+				dest.Set(value.Elem())
+
+				return nil
+			}
+
+			decoder := json.NewDecoder(bytes.NewReader(data))
+
+			if strict {
+				decoder.DisallowUnknownFields()
+			}
+
+			if err := decoder.Decode(value.Interface()); err != nil {
+				dest.SetZero()
+
+				return err
+			}
+
+			dest.Set(value.Elem())
+
+			return nil
+		},
+	}, nil
+}
+
+// ScanParseDurationP is a Scanner to parse strings like '1h30m' into *time.Duration.
+// NULL values result in nil.
+func ScanParseDurationP(dest **time.Duration, str string) (Scanner, error) {
+	var data sql.NullString
+
+	return Scanner{
+		SQL:   str,
+		Value: &data,
+		Map: func() error {
+			if !data.Valid {
+				*dest = nil
+
+				return nil
+			}
+
+			d, err := time.ParseDuration(data.String)
+			if err != nil {
+				*dest = nil
+
+				return err
+			}
+
+			*dest = &d
+
+			return nil
+		},
+	}, nil
+}
+
+// ScanIntBool is a Scanner for booleans stored as integers. Non-zero values result in true, NULL values in false.
+func ScanIntBool(dest *bool, str string) (Scanner, error) {
+	var data sql.NullInt64
+
+	return Scanner{
+		SQL:   str,
+		Value: &data,
+		Map: func() error {
+			*dest = data.Valid && data.Int64 != 0
+
+			return nil
+		},
+	}, nil
+}
+
+// ScanYNBool is a Scanner for booleans stored as 'Y' and 'N' (case-insensitive). NULL values result in false.
+func ScanYNBool(dest *bool, str string) (Scanner, error) {
+	var data sql.NullString
+
+	return Scanner{
+		SQL:   str,
+		Value: &data,
+		Map: func() error {
+			if !data.Valid {
+				*dest = false
+
+				return nil
+			}
+
+			switch strings.ToUpper(strings.TrimSpace(data.String)) {
+			case "Y":
+				*dest = true
+			case "N":
+				*dest = false
+			default:
+				return fmt.Errorf("invalid boolean '%s': expected 'Y' or 'N'", data.String)
+			}
+
+			return nil
+		},
+	}, nil
+}
+
+// ScanParseTimeInLocation is a Scanner to parse strings into time.Time using the layout in the named location,
+// e.g. for naive timestamps in a known timezone. NULL values result in the zero time.
+func ScanParseTimeInLocation(dest *time.Time, str, layout, name string) (Scanner, error) {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return Scanner{}, err
+	}
+
+	var data sql.NullString
+
+	return Scanner{
+		SQL:   str,
+		Value: &data,
+		Map: func() error {
+			if !data.Valid {
+				*dest = time.Time{}
+
+				return nil
+			}
+
+			t, err := time.ParseInLocation(layout, data.String, location)
+			if err != nil {
+				return err
+			}
+
+			*dest = t
+
+			return nil
+		},
+	}, nil
+}
+
+// ScanPGArray is a Scanner to parse one-dimensional Postgres array literals like '{a,"b,c",NULL}' into []string,
+// e.g. for columns aggregated with array_agg. NULL elements result in empty strings and NULL values in nil.
+func ScanPGArray(dest *[]string, str string) (Scanner, error) {
+	var data sql.NullString
+
+	return Scanner{
+		SQL:   str,
+		Value: &data,
+		Map: func() error {
+			if !data.Valid {
+				*dest = nil
+
+				return nil
+			}
+
+			elems, err := parsePGArray(data.String)
+			if err != nil {
+				*dest = nil
+
+				return err
+			}
+
+			*dest = elems
+
+			return nil
+		},
+	}, nil
+}
+
+// parsePGArray parses a one-dimensional Postgres array literal.
+func parsePGArray(str string) ([]string, error) {
+	if len(str) < 2 || str[0] != '{' || str[len(str)-1] != '}' {
+		return nil, fmt.Errorf("invalid array literal '%s'", str)
+	}
+
+	body := str[1 : len(str)-1]
+	elems := []string{}
+
+	if body == "" {
+		return elems, nil
+	}
+
+	var (
+		elem      strings.Builder
+		quoted    bool
+		wasQuoted bool
+	)
+
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+
+		switch {
+		case quoted && c == '\\':
+			i++
+
+			if i >= len(body) {
+				return nil, fmt.Errorf("invalid array literal '%s'", str)
+			}
+
+			elem.WriteByte(body[i])
+		case c == '"':
+			quoted = !quoted
+			wasQuoted = true
+		case quoted:
+			elem.WriteByte(c)
+		case c == '{' || c == '}':
+			return nil, fmt.Errorf("multi-dimensional array literal '%s' is not supported", str)
+		case c == ',':
+			elems = append(elems, pgArrayElem(elem.String(), wasQuoted))
+			elem.Reset()
+			wasQuoted = false
+		default:
+			elem.WriteByte(c)
+		}
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("invalid array literal '%s'", str)
+	}
+
+	return append(elems, pgArrayElem(elem.String(), wasQuoted)), nil
+}
+
+// pgArrayElem maps unquoted NULL elements to empty strings.
+func pgArrayElem(elem string, quoted bool) string {
+	if !quoted && strings.EqualFold(elem, "NULL") {
+		return ""
+	}
+
+	return elem
+}
+
+// ScanFlag is a Scanner for a boolean column, that decides whether dest is populated or set to nil.
+// The fields of the sub-struct must be scanned after the flag column. If the flag is false,
+// these columns must still be scannable (e.g. using COALESCE) and fields of T must not use a Map function.
+func ScanFlag[T any](dest **T, str string) (Scanner, error) {
+	var (
+		flag  bool
+		value = new(T)
+	)
+
+	*dest = value
+
+	return Scanner{
+		SQL:   str,
+		Value: &flag,
+		Map: func() error {
+			if !flag {
+				*dest = nil
+
+				return nil
+			}
+
+			v := *value
+
+			*dest = &v
+
+			return nil
+		},
+	}, nil
+}
+
+func defaultTemplate() *template.Template {
+	return template.New("").Funcs(template.FuncMap{
+		// ident is a stub function
+		ident: func(arg any) (Raw, error) {
+			return "", nil
+		},
+		// Ctx is a stub function
+		"Ctx": func(key string) any {
+			return nil
+		},
+		"Truncate": func(table string) (RunnerFunc, error) {
+			if !validIdent(table) {
+				return nil, fmt.Errorf("invalid table name '%s'", table)
+			}
+
+			return func(runner *Runner) (Raw, error) {
+				switch runner.config.Truncate {
+				case TruncateTable:
+					return Raw("TRUNCATE TABLE " + table), nil
+				case TruncateDelete:
+					return Raw("DELETE FROM " + table), nil
+				}
+
+				switch runner.config.Dialect {
+				case "Postgres", "MySQL", "SQLServer":
+					return Raw("TRUNCATE TABLE " + table), nil
+				default:
+					return Raw("DELETE FROM " + table), nil
+				}
+			}, nil
+		},
+		"Table": func(table string) (RunnerFunc, error) {
+			if !goodName(table) {
+				return nil, fmt.Errorf("invalid table name '%s'", table)
+			}
+
+			return func(runner *Runner) (Raw, error) {
+				if runner.config.Schema == nil {
+					return quoteIdent(runner.config.Dialect, table), nil
+				}
+
+				schema := runner.config.Schema(runner.Context)
+				if schema == "" {
+					return quoteIdent(runner.config.Dialect, table), nil
+				}
+
+				if !goodName(schema) {
+					return "", fmt.Errorf("invalid schema name '%s'", schema)
+				}
+
+				return quoteIdent(runner.config.Dialect, schema) + "." + quoteIdent(runner.config.Dialect, table), nil
+			}, nil
+		},
+		"Raw": func(str string) Raw {
+			return Raw(str)
+		},
+		"TotalCount": func() Raw {
+			return "COUNT(*) OVER() AS total_count"
+		},
+		"Ident": func(name string) (RunnerFunc, error) {
+			if strings.ContainsRune(name, 0) {
+				return nil, fmt.Errorf("invalid identifier '%s'", name)
+			}
+
+			return func(runner *Runner) (Raw, error) {
+				return quoteIdent(runner.config.Dialect, name), nil
+			}, nil
+		},
+		"Any": func(list any) (RunnerFunc, error) {
+			value := reflect.ValueOf(list)
+
+			if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+				return nil, fmt.Errorf("invalid type %T: expected slice or array", list)
+			}
+
+			return func(runner *Runner) (Raw, error) {
+				if runner.config.Dialect == "Postgres" {
+					arg := list
+
+					if runner.config.ArrayArg != nil {
+						arg = runner.config.ArrayArg(list)
+					}
+
+					return "= ANY(" + runner.Bind(arg) + ")", nil
+				}
+
+				if value.Len() == 0 {
+					return "IN (NULL)", nil
+				}
+
+				placeholders := make([]string, value.Len())
+
+				for i := range value.Len() {
+					placeholders[i] = string(runner.Bind(value.Index(i).Interface()))
+				}
+
+				return Raw("IN (" + strings.Join(placeholders, ", ") + ")"), nil
+			}, nil
+		},
+		"In": func(list any) (RunnerFunc, error) {
+			value := reflect.ValueOf(list)
+
+			if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+				return nil, fmt.Errorf("invalid type %T: expected slice or array", list)
+			}
+
+			return func(runner *Runner) (Raw, error) {
+				if value.Len() == 0 {
+					return "NULL", nil
+				}
+
+				placeholders := make([]string, value.Len())
+
+				for i := range value.Len() {
+					placeholders[i] = string(runner.Bind(value.Index(i).Interface()))
+				}
+
+				return Raw(strings.Join(placeholders, ", ")), nil
+			}, nil
+		},
+		"Join": func(list any, sep string) (RunnerFunc, error) {
+			value := reflect.ValueOf(list)
+
+			if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+				return nil, fmt.Errorf("invalid type %T: expected slice or array", list)
+			}
+
+			return func(runner *Runner) (Raw, error) {
+				placeholders := make([]string, value.Len())
+
+				for i := range value.Len() {
+					placeholders[i] = string(runner.Bind(value.Index(i).Interface()))
+				}
+
+				return Raw(strings.Join(placeholders, sep)), nil
+			}, nil
+		},
+		"Bind": func(name string) RunnerFunc {
+			return func(runner *Runner) (Raw, error) {
+				value := reflect.ValueOf(runner.Param)
+
+				for value.Kind() == reflect.Pointer {
+					value = value.Elem()
+				}
+
+				if value.Kind() != reflect.Struct {
+					return "", fmt.Errorf("invalid param type %T: expected struct", runner.Param)
+				}
+
+				field := value.FieldByName(name)
+				if !field.IsValid() || !field.CanInterface() {
+					return "", fmt.Errorf("field '%s' not found in type %s", name, value.Type())
+				}
+
+				return runner.Bind(field.Interface()), nil
+			}
+		},
+		"Arg": func(key string) RunnerFunc {
+			return func(runner *Runner) (Raw, error) {
+				value := reflect.ValueOf(runner.Param)
+
+				for value.Kind() == reflect.Pointer {
+					value = value.Elem()
+				}
+
+				if value.Kind() != reflect.Map || value.Type().Key().Kind() != reflect.String {
+					return "", fmt.Errorf("invalid param type %T: expected map with string keys", runner.Param)
+				}
+
+				elem := value.MapIndex(reflect.ValueOf(key).Convert(value.Type().Key()))
+				if !elem.IsValid() {
+					return "", fmt.Errorf("key '%s' not found in param", key)
+				}
+
+				return runner.Bind(elem.Interface()), nil
+			}
+		},
+		"JSON": func(value any) (RunnerFunc, error) {
+			data, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+
+			return func(runner *Runner) (Raw, error) {
+				return runner.Bind(string(data)), nil
+			}, nil
+		},
+		"Keyset": keyset,
+		"Like":   like,
+		"Upsert": upsert,
+		"OutArg": outArg,
+		"Limit":  clampLimit,
+		"Where": func() *Where {
+			return &Where{}
+		},
+		"LimitOffset": func(limit, offset any) RunnerFunc {
+			return func(runner *Runner) (Raw, error) {
+				return runner.limitOffset(limit, offset)
+			}
+		},
+		"Values": func(list any, fields ...string) (RunnerFunc, error) {
+			value := reflect.ValueOf(list)
+
+			if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+				return nil, fmt.Errorf("invalid type %T: expected slice or array", list)
+			}
+
+			if value.Len() == 0 {
+				return nil, errors.New("no values")
+			}
+
+			rows := make([][]any, value.Len())
+
+			for i := range value.Len() {
+				elem := value.Index(i)
+
+				for elem.Kind() == reflect.Pointer || elem.Kind() == reflect.Interface {
+					if elem.IsNil() {
+						return nil, fmt.Errorf("element %d is nil", i)
+					}
+
+					elem = elem.Elem()
+				}
+
+				if elem.Kind() != reflect.Struct {
+					return nil, fmt.Errorf("invalid type %s: expected struct", elem.Type())
+				}
+
+				rows[i] = make([]any, len(fields))
+
+				for j, name := range fields {
+					sf, ok := elem.Type().FieldByName(name)
+					if !ok {
+						return nil, fmt.Errorf("field '%s' not found in type %s", name, elem.Type())
+					}
+
+					field, err := elem.FieldByIndexErr(sf.Index)
+					if err != nil {
+						return nil, fmt.Errorf("field '%s' of element %d: %w", name, i, err)
+					}
+
+					if !field.CanInterface() {
+						return nil, fmt.Errorf("field '%s' is not exported in type %s", name, elem.Type())
+					}
+
+					rows[i][j] = field.Interface()
+				}
+			}
+
+			return func(runner *Runner) (Raw, error) {
+				tuples := make([]string, len(rows))
+
+				for i, row := range rows {
+					placeholders := make([]string, len(row))
+
+					for j, arg := range row {
+						placeholders[j] = string(runner.Bind(arg))
+					}
+
+					tuples[i] = "(" + strings.Join(placeholders, ", ") + ")"
+				}
+
+				return Raw(strings.Join(tuples, ", ")), nil
+			}, nil
+		},
+		"SplitTime": func(t time.Time) RunnerFunc {
+			return func(runner *Runner) (Raw, error) {
+				return runner.Bind(t.Format(time.DateOnly)) + ", " + runner.Bind(t.Format(time.TimeOnly)), nil
+			}
+		},
+		"Scan":     scanScanner,
+		"ScanNull": scanScanner,
+		"ScanAll":  ScanAll,
+		"ScanJSON": func(dest reflect.Value, str string) (Scanner, error) {
+			return scanJSONValue(dest, str, false)
+		},
+		"ScanJSONStrict": func(dest reflect.Value, str string) (Scanner, error) {
+			return scanJSONValue(dest, str, true)
+		},
+		"ScanString":              Scan[string],
+		"ScanBytes":               Scan[[]byte],
+		"ScanInt":                 Scan[int],
+		"ScanInt8":                Scan[int8],
+		"ScanInt16":               Scan[int16],
+		"ScanInt32":               Scan[int32],
+		"ScanInt64":               Scan[int64],
+		"ScanUint":                Scan[uint],
+		"ScanUint8":               Scan[uint8],
+		"ScanUint16":              Scan[uint16],
+		"ScanUint32":              Scan[uint32],
+		"ScanUint64":              Scan[uint64],
+		"ScanBool":                Scan[bool],
+		"ScanFloat32":             Scan[float32],
+		"ScanFloat64":             Scan[float64],
+		"ScanTime":                Scan[time.Time],
+		"ScanDuration":            Scan[time.Duration],
+		"ScanStringP":             Scan[*string],
+		"ScanBytesP":              Scan[*[]byte],
+		"ScanIntP":                Scan[*int],
+		"ScanInt8P":               Scan[*int8],
+		"ScanInt16P":              Scan[*int16],
+		"ScanInt32P":              Scan[*int32],
+		"ScanInt64P":              Scan[*int64],
+		"ScanUintP":               Scan[*uint],
+		"ScanUint8P":              Scan[*uint8],
+		"ScanUint16P":             Scan[*uint16],
+		"ScanUint32P":             Scan[*uint32],
+		"ScanUint64P":             Scan[*uint64],
+		"ScanBoolP":               Scan[*bool],
+		"ScanFloat32P":            Scan[*float32],
+		"ScanFloat64P":            Scan[*float64],
+		"ScanTimeP":               Scan[*time.Time],
+		"ScanDurationP":           Scan[*time.Duration],
+		"ScanParseDurationP":      ScanParseDurationP,
+		"ScanPGArray":             ScanPGArray,
+		"ScanIntBool":             ScanIntBool,
+		"ScanYNBool":              ScanYNBool,
+		"ScanParseTimeInLocation": ScanParseTimeInLocation,
+	})
+}
+
+// TemplateError is returned, if the execution of a template fails.
+// Snippet contains the lines of the template source around the failing Line. It is empty for templates,
+// that are not parsed by sqlt (e.g. FromTemplate).
+type TemplateError struct {
+	Err     error
+	Line    int
+	Snippet string
+}
+
+// Error implements the error interface.
+func (e TemplateError) Error() string {
+	if e.Snippet == "" {
+		return e.Err.Error()
+	}
+
+	return e.Err.Error() + "\n" + e.Snippet
+}
+
+// Unwrap returns the underlying error.
+func (e TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// newTemplateError resolves the failing line of an ExecError to a snippet of the template source.
+func newTemplateError(tpl *template.Template, err error) error {
+	var execErr template.ExecError
+
+	if !errors.As(err, &execErr) {
+		return err
+	}
+
+	// format: 'template: parseName:line:col: executing ...'
+	location, _, ok := strings.Cut(strings.TrimPrefix(err.Error(), "template: "), ": executing")
+	if !ok {
+		return err
+	}
+
+	parts := strings.Split(location, ":")
+	if len(parts) < 3 {
+		return err
+	}
+
+	line, convErr := strconv.Atoi(parts[len(parts)-2])
+	if convErr != nil {
+		return err
+	}
+
+	text, ok := lookupSource(tpl, strings.Join(parts[:len(parts)-2], ":"))
+	if !ok {
+		return err
+	}
+
+	lines := strings.Split(text, "\n")
+	if line < 1 || line > len(lines) {
+		return err
+	}
+
+	snippet := make([]string, 0, 3)
+
+	for i := max(line-1, 1); i <= min(line+1, len(lines)); i++ {
+		marker := " "
+		if i == line {
+			marker = ">"
+		}
+
+		snippet = append(snippet, fmt.Sprintf("%s %4d | %s", marker, i, strings.TrimRight(lines[i-1], " \t\r")))
+	}
+
+	return TemplateError{
+		Err:     err,
+		Line:    line,
+		Snippet: strings.Join(snippet, "\n"),
+	}
+}
+
+// templateNames returns the sorted names of the defined templates. The unnamed template is omitted.
+func templateNames(tpl *template.Template) []string {
+	var names []string
+
+	for _, t := range tpl.Templates() {
+		if t.Tree != nil && t.Name() != "" && t.Name() != include && !strings.HasPrefix(t.Name(), source) {
+			names = append(names, t.Name())
+		}
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// dialectFuncs returns the template functions 'Dialect', 'IsPostgres', 'IsSqlite', 'IsMySQL' and 'IsSQLServer'.
+func dialectFuncs(dialect func() Dialect) template.FuncMap {
+	is := func(name Dialect) func() bool {
+		return func() bool {
+			return dialect() == name
+		}
+	}
+
+	return template.FuncMap{
+		"Dialect": func() string {
+			return string(dialect())
+		},
+		"IsPostgres":  is("Postgres"),
+		"IsSqlite":    is("Sqlite"),
+		"IsMySQL":     is("MySQL"),
+		"IsSQLServer": is("SQLServer"),
+	}
+}
+
+// Expression is a rendered sql query and its arguments.
+type Expression struct {
+	SQL  string
+	Args []any
+}
+
+// Key returns a deterministic hash of the sql and the arguments, e.g. to cache results by the rendered Expression.
+// Params producing the same sql and arguments share a Key. Arguments are hashed by the type and value of their
+// driver.Value conversion. Pointers are dereferenced and times are compared without monotonic clock reading and location.
+func (e Expression) Key() uint64 {
+	h := fnv.New64a()
+
+	_, _ = io.WriteString(h, e.SQL)
+
+	for _, arg := range e.Args {
+		if named, ok := arg.(sql.NamedArg); ok {
+			_, _ = fmt.Fprintf(h, "\x00@%s", named.Name)
+
+			arg = named.Value
+		}
+
+		arg = keyValue(arg)
+
+		_, _ = fmt.Fprintf(h, "\x00%T:%v", arg, arg)
+	}
+
+	return h.Sum64()
+}
+
+// keyValue normalizes arg for Expression.Key.
+func keyValue(arg any) any {
+	if value, err := driver.DefaultParameterConverter.ConvertValue(arg); err == nil {
+		arg = value
+	} else {
+		for v := reflect.ValueOf(arg); v.Kind() == reflect.Pointer; v = v.Elem() {
+			if v.IsNil() {
+				return nil
+			}
+
+			arg = v.Elem().Interface()
+		}
+	}
+
+	if t, ok := arg.(time.Time); ok {
+		return t.Round(0).UTC()
+	}
+
+	return arg
+}
+
+// Runner groups the relevant data for each 'run' of a Statement.
+type Runner struct {
+	Context        context.Context
+	Template       *template.Template
+	SQL            *SQL
+	Args           []any
+	Param          any
+	Location       string
+	RenderDuration time.Duration
+	Attempt        int
+	config         *Config
+	prepared       *preparedCache
+	validated      *validatedCache
+	base           *Config
+	preparedHit    bool
+	started        time.Time
+	resetScanners  func()
+	span           Span
+	cancel         context.CancelFunc
+}
+
+// Reset the Runner for the next run of a statement.
+func (r *Runner) Reset() {
+	r.Context = nil
+	r.SQL.Reset()
+	r.Args = r.Args[:0]
+	r.Param = nil
+	r.RenderDuration = 0
+	r.Attempt = 0
+	r.preparedHit = false
+	r.started = time.Time{}
+	r.span = nil
+	r.cancel = nil
+	r.config = r.base
+}
+
+// startTimeout derives the context using the Timeout option.
+func (r *Runner) startTimeout() {
+	if r.config.Timeout <= 0 {
+		return
+	}
+
+	r.Context, r.cancel = context.WithTimeout(r.Context, time.Duration(r.config.Timeout))
+}
+
+// rejectTimeout returns an error, if the Timeout option is set, because the results of method are used after
+// the Runner is put back into the pool and the context is cancelled.
+func (r *Runner) rejectTimeout(method string) error {
+	if r.config.Timeout <= 0 {
+		return nil
+	}
+
+	return fmt.Errorf("location: [%s]: Timeout is not supported by %s, use QueryFunc or Scalar", r.Location, method)
+}
+
+// startReadOnly declares the context as read-only using the ReadOnly option.
+func (r *Runner) startReadOnly() {
+	if r.config.ReadOnly {
+		r.Context = WithReadOnly(r.Context)
+	}
+}
+
+// onError transforms err using the OnError option.
+func (r *Runner) onError(err error) error {
+	if err == nil || r.config.OnError == nil {
+		return err
+	}
+
+	return r.config.OnError(err, r)
+}
+
+// switchDialect applies the dialect of the DialectFunc option for this run.
+func (r *Runner) switchDialect() {
+	if r.config.DialectFunc == nil {
+		return
+	}
+
+	name := Dialect(r.config.DialectFunc(r.Context))
+	if name == "" || name == r.config.Dialect {
+		return
+	}
+
+	config := r.config.copy()
+
+	name.Configure(&config)
+
+	r.config = &config
+}
+
+// startSpan starts a Span using the Tracer option.
+func (r *Runner) startSpan() {
+	if r.config.Tracer == nil {
+		return
+	}
+
+	r.Context, r.span = r.config.Tracer.Start(r.Context, r.Location)
+}
+
+// endSpan records the attributes and ends the Span.
+func (r *Runner) endSpan(err error) {
+	if r.span == nil {
+		return
+	}
+
+	r.span.SetAttribute("db.statement", r.SQL.String())
+	r.span.SetAttribute("db.args", len(r.Args))
+	r.span.SetAttribute("sqlt.attempt", r.Attempt)
+	r.span.SetAttribute("sqlt.prepared", r.preparedHit)
+	r.span.End(err)
+}
+
+// Bind appends arg to the Args of the Runner and returns the placeholder.
+func (r *Runner) Bind(arg any) Raw {
+	if d, ok := arg.(time.Duration); ok && bool(r.config.IntervalArgs) {
+		arg = intervalArg(r.config.Dialect, d)
+	}
+
+	if r.config.MarshalArgs {
+		arg = marshalArg(arg)
+	}
+
+	if r.config.Named != "" {
+		name := fmt.Sprintf("arg%d", len(r.Args)+1)
+
+		r.Args = append(r.Args, sql.Named(name, arg))
+
+		return Raw(string(r.config.Named) + name)
+	}
+
+	r.Args = append(r.Args, arg)
+
+	if strings.Contains(string(r.config.Placeholder), "%d") {
+		return Raw(fmt.Sprintf(string(r.config.Placeholder), len(r.Args)))
+	}
+
+	return Raw(r.config.Placeholder)
+}
+
+// intervalArg converts d into a Postgres interval in the largest exact unit or into seconds for other dialects.
+// Postgres intervals have a resolution of microseconds, so d is rounded to the nearest microsecond (e.g. 7ns to 0).
+func intervalArg(dialect Dialect, d time.Duration) any {
+	if dialect != "Postgres" {
+		return d.Seconds()
+	}
+
+	d = d.Round(time.Microsecond)
+
+	for _, unit := range []struct {
+		size time.Duration
+		name string
+	}{
+		{time.Hour, "hours"},
+		{time.Minute, "minutes"},
+		{time.Second, "seconds"},
+		{time.Millisecond, "milliseconds"},
+	} {
+		if d%unit.size == 0 {
+			return fmt.Sprintf("%d %s", d/unit.size, unit.name)
+		}
+	}
+
+	return fmt.Sprintf("%d microseconds", d.Microseconds())
+}
+
+// marshalArg converts arg using encoding.TextMarshaler or json.Marshaler, if it is not a driver.Valuer
+// and not supported by the default parameter converter. On errors, arg is returned unchanged.
+func marshalArg(arg any) any {
+	if _, ok := arg.(driver.Valuer); ok {
+		return arg
+	}
+
+	if _, err := driver.DefaultParameterConverter.ConvertValue(arg); err == nil {
+		return arg
+	}
+
+	switch a := arg.(type) {
+	case encoding.TextMarshaler:
+		if text, err := a.MarshalText(); err == nil {
+			return string(text)
+		}
+	case json.Marshaler:
+		if data, err := a.MarshalJSON(); err == nil {
+			return string(data)
+		}
+	}
+
+	return arg
+}
+
+// limitOffset binds limit and offset. The 'SQLServer' dialect uses 'OFFSET ... FETCH' and requires a preceding 'ORDER BY'.
+func (r *Runner) limitOffset(limit, offset any) (Raw, error) {
+	if r.config.Dialect == "SQLServer" {
+		if !hasOrderBy(r.SQL.data) {
+			return "", errors.New("OFFSET ... FETCH requires a preceding ORDER BY")
+		}
+
+		return "OFFSET " + r.Bind(offset) + " ROWS FETCH NEXT " + r.Bind(limit) + " ROWS ONLY", nil
+	}
+
+	return "LIMIT " + r.Bind(limit) + " OFFSET " + r.Bind(offset), nil
+}
+
+// hasOrderBy reports whether the current statement of data contains an 'ORDER BY' at the nesting level of the end
+// of data, e.g. the top-level statement or the open subquery. Literals, quoted identifiers and comments are skipped.
+func hasOrderBy(data []byte) bool {
+	found := []bool{false}
+
+	skip := func(i int, end string) int {
+		if j := bytes.Index(data[i:], []byte(end)); j >= 0 {
+			return i + j + len(end)
+		}
+
+		return len(data)
+	}
+
+	word := func(i int) (string, int) {
+		j := i
+
+		for j < len(data) && (data[j] == '_' || unicode.IsLetter(rune(data[j])) || unicode.IsDigit(rune(data[j]))) {
+			j++
+		}
+
+		return string(data[i:j]), j
+	}
+
+	for i := 0; i < len(data); {
+		switch c := data[i]; {
+		case c == '\'':
+			// doubled quotes are read as two adjacent literals.
+			i = skip(i+1, "'")
+		case c == '"' || c == '`':
+			i = skip(i+1, string(c))
+		case c == '[':
+			i = skip(i+1, "]")
+		case c == '-' && i+1 < len(data) && data[i+1] == '-':
+			i = skip(i+2, "\n")
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			i = skip(i+2, "*/")
+		case c == '(':
+			found = append(found, false)
+			i++
+		case c == ')':
+			if len(found) > 1 {
+				found = found[:len(found)-1]
+			}
+
+			i++
+		case c == ';' && len(found) == 1:
+			found[0] = false
+			i++
+		case c == '_' || unicode.IsLetter(rune(c)):
+			var w string
+
+			w, i = word(i)
+
+			if !strings.EqualFold(w, "ORDER") {
+				continue
+			}
+
+			j := i
+
+			for j < len(data) && unicode.IsSpace(rune(data[j])) {
+				j++
+			}
+
+			if next, end := word(j); j > i && strings.EqualFold(next, "BY") {
+				found[len(found)-1] = true
+				i = end
+			}
+		default:
+			i++
+		}
+	}
+
+	return found[len(found)-1]
+}
+
+// render validates the param, executes the template and finishes the sql.
+func (r *Runner) render(param any) error {
+	if err := r.validateParam(param); err != nil {
+		return err
+	}
+
+	if err := r.execute(r.Template, param); err != nil {
+		return err
+	}
+
+	if err := r.assertDeterministic(param); err != nil {
+		return err
+	}
+
+	return r.finish()
+}
+
+// assertDeterministic renders the template again and compares the sql and args, if AssertDeterministic is set.
+func (r *Runner) assertDeterministic(param any) error {
+	if !r.config.Deterministic {
+		return nil
+	}
+
+	str, args := r.SQL.String(), slices.Clone(r.Args)
+
+	r.SQL.Reset()
+	r.Args = r.Args[:0]
+
+	if r.resetScanners != nil {
+		r.resetScanners()
+	}
+
+	if err := r.execute(r.Template, param); err != nil {
+		return err
+	}
+
+	if r.SQL.String() != str || !reflect.DeepEqual(r.Args, args) {
+		return fmt.Errorf("location: [%s]: non-deterministic template: '%s' %v and '%s' %v", r.Location, str, args, r.SQL.String(), r.Args)
+	}
+
+	return nil
+}
+
+// validateParam validates the param using the ValidateParam option.
+func (r *Runner) validateParam(param any) error {
+	if r.config.ValidateParam == nil {
+		return nil
+	}
+
+	if err := r.config.ValidateParam(param); err != nil {
+		return fmt.Errorf("location: [%s]: %w", r.Location, err)
+	}
+
+	return nil
+}
+
+// finish validates the rendered sql and appends the comment.
+func (r *Runner) finish() error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+
+	r.comment()
+
+	return nil
+}
+
+// comment appends the key-value pairs of the Comment option in the sqlcommenter format.
+func (r *Runner) comment() {
+	if r.config.Comment == nil {
+		return
+	}
+
+	tags := r.config.Comment(r.Context)
+	if len(tags) == 0 {
+		return
+	}
+
+	pairs := make([]string, 0, len(tags))
+
+	for _, key := range slices.Sorted(maps.Keys(tags)) {
+		pairs = append(pairs, url.PathEscape(key)+"='"+url.PathEscape(tags[key])+"'")
+	}
+
+	_, _ = r.SQL.Write([]byte(" /*" + strings.Join(pairs, ",") + "*/"))
+}
+
+// execute tpl after binding the PrependArgs and measure the RenderDuration.
+func (r *Runner) execute(tpl *template.Template, param any) error {
+	r.Param = param
+
+	if r.config.PrependArgs != nil {
+		for _, arg := range r.config.PrependArgs(r.Context) {
+			_ = r.Bind(arg)
+		}
+	}
+
+	now := time.Now()
+
+	err := tpl.Execute(r.SQL, param)
+
+	r.RenderDuration = time.Since(now)
+
+	if r.config.SlowRender.Warn != nil && r.RenderDuration > r.config.SlowRender.Threshold {
+		r.config.SlowRender.Warn(r)
+	}
+
+	if err != nil {
+		return newTemplateError(tpl, err)
+	}
+
+	return nil
+}
+
+// validate the rendered sql once using the Validate option.
+func (r *Runner) validate() error {
+	if r.config.Validate == nil {
+		return nil
+	}
+
+	str := r.SQL.String()
+
+	if r.validated.contains(str) {
+		return nil
+	}
+
+	if err := r.config.Validate(r.Context, str); err != nil {
+		return err
+	}
+
+	r.validated.add(str)
+
+	return nil
+}
+
+// Exec creates and execute the sql query using ExecContext.
+func (r *Runner) Exec(db DB, param any) (sql.Result, error) {
+	if err := r.render(param); err != nil {
+		return nil, err
+	}
+
+	str, args, err := r.rewrite()
+	if err != nil {
+		return nil, err
+	}
+
+	db, release, err := r.prepare(db, str)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	var result sql.Result
+
+	err = r.retry(func() error {
+		result, err = db.ExecContext(r.Context, str, args...)
+
+		return err
+	})
+
+	return result, err
+}
+
+// Query creates and execute the sql query using QueryContext.
+func (r *Runner) Query(db DB, param any) (*sql.Rows, error) {
+	if err := r.render(param); err != nil {
+		return nil, err
+	}
+
+	return r.query(db)
+}
+
+// query executes the rendered sql using QueryContext.
+func (r *Runner) query(db DB) (*sql.Rows, error) {
+	str, args, err := r.rewrite()
+	if err != nil {
+		return nil, err
+	}
+
+	db, release, err := r.prepare(db, str)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	var rows *sql.Rows
+
+	err = r.retry(func() error {
+		rows, err = db.QueryContext(r.Context, str, args...)
+
+		return err
+	})
+
+	return rows, err
+}
+
+// Query creates and execute the sql query using QueryRow.
+func (r *Runner) QueryRow(db DB, param any) (*sql.Row, error) {
+	if err := r.render(param); err != nil {
+		return nil, err
+	}
+
+	return r.queryRow(db)
+}
+
+// queryRow executes the rendered sql using QueryRowContext.
+func (r *Runner) queryRow(db DB) (*sql.Row, error) {
+	str, args, err := r.rewrite()
+	if err != nil {
+		return nil, err
+	}
+
+	db, release, err := r.prepare(db, str)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	var row *sql.Row
+
+	_ = r.retry(func() error {
+		row = db.QueryRowContext(r.Context, str, args...)
+
+		return row.Err()
+	})
+
+	return row, nil
+}
+
+// explain queries the plan of the rendered sql. The last column of each row is a line of the plan.
+func (r *Runner) explain(db DB) (string, error) {
+	str, args, err := r.rewrite()
+	if err != nil {
+		return "", err
+	}
+
+	switch r.config.Dialect {
+	case "Postgres":
+		str = "EXPLAIN (ANALYZE, FORMAT TEXT) " + str
+	case "MySQL":
+		str = "EXPLAIN ANALYZE " + str
+	case "SQLServer":
+		return "", errors.New("explain is not supported by the dialect 'SQLServer'")
+	default:
+		str = "EXPLAIN QUERY PLAN " + str
+	}
+
+	rows, err := db.QueryContext(r.Context, str, args...)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+
+	if len(columns) == 0 {
+		return "", errors.New("explain returned no columns")
+	}
+
+	var (
+		lines  []string
+		values = make([]any, len(columns))
+		line   sql.NullString
+	)
+
+	for i := range values {
+		values[i] = new(any)
+	}
+
+	values[len(values)-1] = &line
+
+	for rows.Next() {
+		if err = rows.Scan(values...); err != nil {
+			return "", err
+		}
+
+		lines = append(lines, line.String)
+	}
+
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// rewrite returns the rendered sql and args transformed by the Rewrite options.
+func (r *Runner) rewrite() (string, []any, error) {
+	str := r.SQL.String()
+
+	if len(r.config.Rewrite) == 0 {
+		return str, r.Args, nil
+	}
+
+	args := r.Args
+
+	if !r.config.UnsafeReuseArgs {
+		args = slices.Clone(args)
+	}
+
+	for _, rw := range r.config.Rewrite {
+		var err error
+
+		str, args, err = rw(r.Context, str, args)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	return str, args, nil
+}
+
+// prepare returns a DB using a cached prepared statement for str, if the Prepare option is set.
+// The returned release func must be called after the execution. Rows keep the statement open until they are closed.
+func (r *Runner) prepare(db DB, str string) (DB, func(), error) {
+	if r.prepared == nil {
+		return db, func() {}, nil
+	}
+
+	if _, ok := db.(*sql.Tx); ok {
+		return db, func() {}, nil
+	}
+
+	p, ok := db.(preparer)
+	if !ok {
+		return db, func() {}, nil
+	}
+
+	entry, hit, err := r.prepared.get(r.Context, p, str)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.preparedHit = hit
+
+	return stmtDB{stmt: entry.stmt}, func() { r.prepared.release(entry) }, nil
+}
+
+// retry executes do according to the RetryPolicy and records the Attempt.
+// It stops waiting for the next attempt, if the context is done.
+func (r *Runner) retry(do func() error) error {
+	for r.Attempt = 1; ; r.Attempt++ {
+		err := do()
+		if err == nil || r.Attempt >= r.config.Retry.Attempts || r.config.Retry.Retryable == nil || !r.config.Retry.Retryable(err) {
+			return err
+		}
+
+		var wait time.Duration
+
+		if r.config.Retry.Backoff != nil {
+			wait = r.config.Retry.Backoff(r.Attempt)
+		}
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-r.Context.Done():
+			timer.Stop()
+
+			return errors.Join(err, r.Context.Err())
+		case <-timer.C:
+		}
+
+		// the last attempt is ended, when the Runner is put back into the pool.
+		if r.config.End != nil {
+			r.config.End(err, r)
+		}
+
+		if r.config.Start != nil {
+			r.config.Start(r)
+		}
+	}
+}
+
+// Stmt creates a type-safe Statement using variadic options.
+// Invalid templates panic.
+func Stmt[Param any](opts ...Option) *Statement[Param] {
+	_, file, line, _ := runtime.Caller(1)
+
+	location := fmt.Sprintf("%s:%d", file, line)
+
+	config := &Config{
+		Placeholder: "?",
+	}
+
+	for _, opt := range opts {
+		opt.Configure(config)
+	}
+
+	var (
+		tpl = defaultTemplate().Funcs(dialectFuncs(func() Dialect {
+			return config.Dialect
+		})).Funcs(template.FuncMap{
+			"Dest": func() any {
+				return nil
+			},
+		})
+		err error
+	)
+
+	for _, to := range config.TemplateOptions {
+		tpl, err = to(tpl)
+		if err != nil {
+			panic(fmt.Errorf("location: [%s]: %w", location, err))
+		}
+	}
+
+	if err = checkTemplates(tpl, *new(Param)); err != nil {
+		panic(fmt.Errorf("location: [%s]: %w", location, err))
+	}
+
+	escape(tpl)
+
+	var (
+		prepared  *preparedCache
+		validated = newValidatedCache()
+	)
+
+	if config.Prepare > 0 {
+		prepared = newPreparedCache(int(config.Prepare))
+	}
+
+	stmt := &Statement[Param]{
+		config:    config,
+		start:     config.Start,
+		end:       config.End,
+		prepared:  prepared,
+		validated: validated,
+		templates: templateNames(tpl),
+		pool: &sync.Pool{
+			New: func() any {
+				t, err := tpl.Clone()
+				if err != nil {
+					panic(fmt.Errorf("location: [%s]: %w", location, err))
+				}
+
+				runner := &Runner{
+					Template:  t,
+					SQL:       &SQL{preserve: bool(config.Whitespace), compact: bool(config.CompactParens), max: int(config.MaxSQLSize)},
+					Location:  location,
+					config:    config,
+					prepared:  prepared,
+					validated: validated,
+					base:      config,
+				}
+
+				t.Funcs(dialectFuncs(func() Dialect {
+					return runner.config.Dialect
+				}))
+
+				t.Funcs(template.FuncMap{
+					"Ctx": func(key string) any {
+						return runner.Context.Value(ContextKey(key))
+					},
+					ident: func(arg any) (Raw, error) {
+						switch a := arg.(type) {
+						case Raw:
+							return a, nil
+						case RunnerFunc:
+							return a(runner)
+						default:
+							return runner.Bind(arg), nil
+						}
+					},
+				})
+
+				return runner
+			},
+		},
+	}
+
+	if config.Registry != nil {
+		config.Registry.register(location, stmt)
+	}
+
+	return stmt
+}
+
+// Statements is a Runner pool and a type-safe sql executor.
+type Statement[Param any] struct {
+	config    *Config
+	start     func(runner *Runner)
+	end       func(err error, runner *Runner)
+	pool      *sync.Pool
+	prepared  *preparedCache
+	validated *validatedCache
+	templates []string
+}
+
+// Templates returns the sorted names of the defined templates.
+func (s *Statement[Param]) Templates() []string {
+	return slices.Clone(s.templates)
+}
+
+// Stats returns the Stats of the cache of prepared statements (see the Prepare option).
+func (s *Statement[Param]) Stats() Stats {
+	return s.prepared.Stats()
+}
+
+// Close closes the cached prepared statements and forgets the validated sql. Statements in use are closed after their execution.
+// The Statement can still be used afterwards.
+func (s *Statement[Param]) Close() error {
+	s.validated.clear()
+
+	return s.prepared.close()
+}
+
+// Get a Runner from the pool and execute the start option.
+func (s *Statement[Param]) Get(ctx context.Context) *Runner {
+	return s.get(ctx, nil)
+}
+
+// get a Runner from the pool using the overridden config, if it is not nil, and execute the start option.
+func (s *Statement[Param]) get(ctx context.Context, config *Config) *Runner {
+	runner := s.pool.Get().(*Runner)
+
+	if config != nil {
+		runner.config = config
+	}
+
+	runner.Context = ctx
+
+	runner.switchDialect()
+
+	runner.startTimeout()
+
+	runner.startReadOnly()
+
+	runner.startSpan()
+
+	if s.start != nil {
+		s.start(runner)
+	}
+
+	return runner
+}
+
+// Put a Runner into the pool and execute the end option.
+func (s *Statement[Param]) Put(err error, runner *Runner) {
+	_ = s.put(err, runner)
+}
+
+// put a Runner into the pool and return the error transformed by the OnError option.
+func (s *Statement[Param]) put(err error, runner *Runner) error {
+	if s.end != nil {
+		s.end(err, runner)
+	}
+
+	runner.endSpan(err)
+
+	err = runner.onError(err)
+
+	if runner.cancel != nil {
+		runner.cancel()
+	}
+
+	runner.Reset()
+
+	s.pool.Put(runner)
+
+	return err
+}
+
+// Render takes a runner and renders the Expression without executing it.
+func (s *Statement[Param]) Render(ctx context.Context, param Param) (expr Expression, err error) {
+	runner := s.Get(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+
+		err = s.put(err, runner)
+	}()
+
+	if err = runner.render(param); err != nil {
+		return expr, err
+	}
+
+	return Expression{
+		SQL:  runner.SQL.String(),
+		Args: slices.Clone(runner.Args),
+	}, nil
+}
+
+// Explain renders the sql, prefixes it with the dialect-specific EXPLAIN and returns the plan as text.
+// The 'Postgres' and 'MySQL' dialects use EXPLAIN ANALYZE, which executes the sql. 'SQLServer' is not supported
+// and other dialects use 'EXPLAIN QUERY PLAN'.
+func (s *Statement[Param]) Explain(ctx context.Context, db DB, param Param) (plan string, err error) {
+	runner := s.Get(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+
+		err = s.put(err, runner)
+	}()
+
+	if err = runner.render(param); err != nil {
+		return "", err
+	}
+
+	return runner.explain(db)
+}
+
+// renderZero renders the template with the zero value of Param, without executing the start, end and ValidateParam options.
+func (s *Statement[Param]) renderZero(ctx context.Context) (err error) {
+	runner := s.pool.Get().(*Runner)
+
+	runner.Context = ctx
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+
+		runner.Reset()
+
+		s.pool.Put(runner)
+	}()
+
+	if err = runner.execute(runner.Template, *new(Param)); err != nil {
+		return err
+	}
+
+	return runner.finish()
+}
+
+// Exec takes a runner and executes it.
+func (s *Statement[Param]) Exec(ctx context.Context, db DB, param Param) (result sql.Result, err error) {
+	runner := s.Get(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+
+		err = s.put(err, runner)
+	}()
+
+	return runner.Exec(db, param)
+}
+
+// ExecEach executes the statement for each param. Each execution takes its own runner, so the start and end options
+// are executed per param. It stops at the first error and returns the results so far.
+// Pass a transaction (e.g. from InTx) to roll back all executions on errors.
+func (s *Statement[Param]) ExecEach(ctx context.Context, db DB, params []Param) ([]sql.Result, error) {
+	results := make([]sql.Result, 0, len(params))
+
+	for _, param := range params {
+		result, err := s.Exec(ctx, db, param)
+		if err != nil {
+			return results, err
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// ExecID takes a runner, executes it and returns the LastInsertId of the result (e.g. Sqlite and MySQL).
+// For drivers without support (e.g. Postgres), use ExecReturning of a QueryStatement.
+func (s *Statement[Param]) ExecID(ctx context.Context, db DB, param Param) (id int64, err error) {
+	runner := s.Get(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+
+		err = s.put(err, runner)
+	}()
+
+	var result sql.Result
+
+	result, err = runner.Exec(db, param)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err = result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("last insert id is not supported, use ExecReturning: %w", err)
+	}
+
+	return id, nil
+}
+
+// ExecWith takes a runner and executes it using the overridden options, e.g. a different Placeholder for a replica.
+// The overrides are applied before the runner is started, so Timeout, Tracer, DialectFunc and the dialect template
+// functions are affected. Options, that are fixed when the statement is created, are rejected (see fixedOptions).
+func (s *Statement[Param]) ExecWith(ctx context.Context, db DB, param Param, opts ...Option) (result sql.Result, err error) {
+	var override Config
+
+	for _, opt := range opts {
+		opt.Configure(&override)
+	}
+
+	if fixed := fixedOptions(override); len(fixed) > 0 {
+		return nil, fmt.Errorf("options cannot be overridden: %s", strings.Join(fixed, ", "))
+	}
+
+	config := s.config.copy()
+
+	for _, opt := range opts {
+		opt.Configure(&config)
+	}
+
+	runner := s.get(ctx, &config)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+
+		err = s.put(err, runner)
+	}()
+
+	return runner.Exec(db, param)
+}
+
+// fixedOptions returns the names of the options set in config, that are fixed when a statement is created.
+func fixedOptions(config Config) []string {
+	var fixed []string
+
+	for name, set := range map[string]bool{
+		"Start":              config.Start != nil,
+		"End":                config.End != nil,
+		"Prepare":            config.Prepare != 0,
+		"PreserveWhitespace": bool(config.Whitespace),
+		"CompactParens":      bool(config.CompactParens),
+		"MaxSQLSize":         config.MaxSQLSize != 0,
+		"RequireAllFields":   bool(config.RequireAllFields),
+		"FoldCase":           bool(config.FoldCase),
+		"Registry":           config.Registry != nil,
+		"TemplateOptions":    len(config.TemplateOptions) > 0,
+	} {
+		if set {
+			fixed = append(fixed, name)
+		}
+	}
+
+	slices.Sort(fixed)
+
+	return fixed
+}
+
+// ExecTx executes the statement in a transaction using InTx.
+// The transaction is committed on success and rolled back on errors and panics.
+func (s *Statement[Param]) ExecTx(ctx context.Context, db *sql.DB, opts *sql.TxOptions, param Param) (result sql.Result, err error) {
+	err = InTx(ctx, opts, db, func(tx DB) error {
+		result, err = s.Exec(ctx, tx, param)
+
+		return err
+	})
+
+	return result, err
+}
+
+// QueryRow takes a runner and queries a row.
+func (s *Statement[Param]) QueryRow(ctx context.Context, db DB, param Param) (row *sql.Row, err error) {
+	runner := s.Get(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+
+		err = s.put(err, runner)
+	}()
+
+	if err = runner.rejectTimeout("QueryRow"); err != nil {
+		return nil, err
+	}
+
+	row, err = runner.QueryRow(db, param)
+	if err != nil {
+		return row, err
+	}
+
+	return row, row.Err()
+}
+
+// Count queries a row and scans the first column into an int64, e.g. for 'SELECT COUNT(*) FROM books'.
+func (s *Statement[Param]) Count(ctx context.Context, db DB, param Param) (int64, error) {
+	return Scalar[int64](ctx, s, db, param)
+}
+
+// Scalar queries a row of a single column and scans it into T, e.g. for 'SELECT MAX(price) FROM books'.
+// If there is no row, sql.ErrNoRows is returned.
+func Scalar[T, Param any](ctx context.Context, s *Statement[Param], db DB, param Param) (value T, err error) {
+	runner := s.Get(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+
+		err = s.put(err, runner)
+	}()
+
+	var row *sql.Row
+
+	row, err = runner.QueryRow(db, param)
+	if err != nil {
+		return value, err
+	}
+
+	err = row.Scan(&value)
+
+	return value, err
+}
+
+// Query takes a runner and queries rows.
+func (s *Statement[Param]) Query(ctx context.Context, db DB, param Param) (rows *sql.Rows, err error) {
+	runner := s.Get(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+
+		err = s.put(err, runner)
+	}()
+
+	if err = runner.rejectTimeout("Query"); err != nil {
+		return nil, err
+	}
+
+	return runner.Query(db, param)
+}
+
+// QueryFunc queries rows and passes them to do. Unlike Query, the rows are closed afterwards and rows.Err is checked.
+// Timeouts and the end option span the execution of do.
+func (s *Statement[Param]) QueryFunc(ctx context.Context, db DB, param Param, do func(rows *sql.Rows) error) (err error) {
+	runner := s.Get(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+
+		err = s.put(err, runner)
+	}()
+
+	var rows *sql.Rows
+
+	rows, err = runner.Query(db, param)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err = errors.Join(err, rows.Close())
+	}()
+
+	if err = do(rows); err != nil {
+		return err
+	}
+
+	return rows.Err()
+}
+
+// AllMap queries rows and returns a map of column names to values for each row.
+// The values are returned by the driver. Byte slices are converted to strings.
+func (s *Statement[Param]) AllMap(ctx context.Context, db DB, param Param) (result []map[string]any, err error) {
+	runner := s.Get(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+
+		err = s.put(err, runner)
+	}()
+
+	var rows *sql.Rows
+
+	rows, err = runner.Query(db, param)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		err = errors.Join(err, rows.Close())
+	}()
+
+	var columns []string
+
+	columns, err = rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]any, len(columns))
+	pointers := make([]any, len(columns))
+
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err = rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+
+		row := make(map[string]any, len(columns))
+
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				row[column] = string(b)
+			} else {
+				row[column] = values[i]
+			}
+		}
+
+		result = append(result, row)
+	}
+
+	return result, rows.Err()
+}
+
+// Two queries two result sets (e.g. of a stored procedure) and maps the first into A and the second into B.
+// Columns are matched to struct fields by a 'sql' tag or by the field name. Other types are scanned from a single column.
+// The driver must support multiple result sets (see sql.Rows.NextResultSet).
+func Two[A, B, Param any](ctx context.Context, s *Statement[Param], db DB, param Param) (a []A, b []B, err error) {
+	runner := s.Get(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+
+		err = s.put(err, runner)
+	}()
+
+	var rows *sql.Rows
+
+	rows, err = runner.Query(db, param)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer func() {
+		err = errors.Join(err, rows.Close())
+	}()
+
+	a, err = scanResultSet[A](rows, bool(runner.config.FoldCase))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !rows.NextResultSet() {
+		return nil, nil, errors.Join(errors.New("missing second result set"), rows.Err())
+	}
+
+	b, err = scanResultSet[B](rows, bool(runner.config.FoldCase))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return a, b, nil
+}
+
+// scanResultSet scans the rows of the current result set into T. If fold is true, the columns are matched case-insensitively.
+func scanResultSet[T any](rows *sql.Rows, fold bool) ([]T, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []T
+
+	for rows.Next() {
+		var (
+			dest   T
+			value  = reflect.ValueOf(&dest).Elem()
+			values []any
+		)
+
+		if value.Kind() == reflect.Struct && !reflect.PointerTo(value.Type()).Implements(reflect.TypeFor[sql.Scanner]()) &&
+			value.Type() != reflect.TypeFor[time.Time]() {
+			values = make([]any, len(columns))
+
+			for i, column := range columns {
+				field, ok := fieldByColumn(value, column, fold)
+				if !ok {
+					return nil, fmt.Errorf("no field for column '%s' in type %s", column, value.Type())
+				}
+
+				values[i] = field.Addr().Interface()
+			}
+		} else {
+			if len(columns) != 1 {
+				return nil, fmt.Errorf("cannot scan %d columns into type %s", len(columns), value.Type())
+			}
+
+			values = []any{&dest}
+		}
+
+		if err = rows.Scan(values...); err != nil {
+			return nil, err
+		}
+
+		result = append(result, dest)
+	}
+
+	return result, rows.Err()
+}
+
+// QueryRunner groups the relevant data for each 'run' of a QueryStatement.
+type QueryRunner[Dest any] struct {
+	Runner  *Runner
+	Dest    *Dest
+	Values  []any
+	Mappers []func() error
+	columns []string
+}
+
+// Reset the QueryRunner for the next run of a statement.
+func (qr *QueryRunner[Dest]) Reset() {
+	qr.Runner.Reset()
+	qr.Values = qr.Values[:0]
+	qr.Mappers = qr.Mappers[:0]
+	qr.columns = qr.columns[:0]
+}
+
+// QueryStmt creates a type-safe QueryStatement using variadic options.
+// Define the mapping of a column to a struct field here using the Scan functions.
+// Invalid templates panic.
+func QueryStmt[Param, Dest any](opts ...Option) *QueryStatement[Param, Dest] {
+	_, file, line, _ := runtime.Caller(1)
+
+	location := fmt.Sprintf("%s:%d", file, line)
+
+	config := &Config{
+		Placeholder: "?",
+	}
+
+	for _, opt := range opts {
+		opt.Configure(config)
+	}
+
+	var (
+		tpl = defaultTemplate().Funcs(dialectFuncs(func() Dialect {
+			return config.Dialect
+		})).Funcs(template.FuncMap{
+			"Dest": func() *Dest {
+				return new(Dest)
+			},
+		})
+		err error
+	)
+
+	destType := reflect.TypeFor[Dest]().Name()
+
+	if goodName(destType) {
+		tpl.Funcs(template.FuncMap{
+			destType: func() *Dest {
+				return new(Dest)
+			},
+		})
+	}
+
+	if config.FoldCase {
+		tpl.Funcs(template.FuncMap{
+			"ScanAll": func(dest any, columns string) (Scanners, error) {
+				return scanAll(dest, columns, true)
+			},
+		})
+	}
+
+	for _, to := range config.TemplateOptions {
+		tpl, err = to(tpl)
+		if err != nil {
+			panic(fmt.Errorf("location: [%s]: %w", location, err))
+		}
+	}
+
+	err = errors.Join(checkTemplates(tpl, *new(Param)), checkScanAll(tpl, new(Dest), bool(config.FoldCase), "Dest", destType))
+
+	if config.RequireAllFields {
+		err = errors.Join(err, requireAllFields(tpl, reflect.TypeFor[Dest](), "Dest", destType))
+	}
+
+	if err != nil {
+		panic(fmt.Errorf("location: [%s]: %w", location, err))
+	}
+
+	escape(tpl)
+
+	var (
+		prepared  *preparedCache
+		validated = newValidatedCache()
+	)
+
+	if config.Prepare > 0 {
+		prepared = newPreparedCache(int(config.Prepare))
+	}
+
+	stmt := &QueryStatement[Param, Dest]{
+		start:     config.Start,
+		end:       config.End,
+		prepared:  prepared,
+		validated: validated,
+		templates: templateNames(tpl),
+		pool: &sync.Pool{
+			New: func() any {
+				t, err := tpl.Clone()
+				if err != nil {
+					panic(fmt.Errorf("clone: location: [%s]: %w", location, err))
+				}
+
+				runner := &QueryRunner[Dest]{
+					Runner: &Runner{
+						Template:  t,
+						SQL:       &SQL{preserve: bool(config.Whitespace), compact: bool(config.CompactParens), max: int(config.MaxSQLSize)},
+						Location:  location,
+						config:    config,
+						prepared:  prepared,
+						validated: validated,
+						base:      config,
+					},
+					Dest: new(Dest),
+				}
+
+				runner.Runner.resetScanners = func() {
+					runner.Values = runner.Values[:0]
+					runner.Mappers = runner.Mappers[:0]
+					runner.columns = runner.columns[:0]
+				}
+
+				if config.DialectFunc != nil {
+					t.Funcs(dialectFuncs(func() Dialect {
+						return runner.Runner.config.Dialect
+					}))
+				}
+
+				if goodName(destType) {
+					t.Funcs(template.FuncMap{
+						destType: func() *Dest {
+							return runner.Dest
+						},
+					})
+				}
+
+				t.Funcs(template.FuncMap{
+					"Dest": func() *Dest {
+						return runner.Dest
+					},
+					"Ctx": func(key string) any {
+						return runner.Runner.Context.Value(ContextKey(key))
+					},
+					ident: func(arg any) (Raw, error) {
+						switch a := arg.(type) {
+						case Raw:
+							return a, nil
+						case RunnerFunc:
+							return a(runner.Runner)
+						case Scanner:
+							runner.Values = append(runner.Values, a.Value)
+							runner.Mappers = append(runner.Mappers, a.Map)
+							runner.columns = append(runner.columns, columnName(a.SQL))
+
+							return Raw(a.SQL), nil
+						case Scanners:
+							columns := make([]string, len(a))
+
+							for i, scanner := range a {
+								runner.Values = append(runner.Values, scanner.Value)
+								runner.Mappers = append(runner.Mappers, scanner.Map)
+								runner.columns = append(runner.columns, columnName(scanner.SQL))
+
+								columns[i] = scanner.SQL
+							}
+
+							return Raw(strings.Join(columns, ", ")), nil
+						default:
+							return runner.Runner.Bind(arg), nil
+						}
+					},
+				})
+
+				return runner
+			},
+		},
+	}
+
+	if config.Registry != nil {
+		config.Registry.register(location, stmt)
+	}
+
+	return stmt
+}
+
+// QueryStatement is a QueryRunner pool and a type-safe sql query executor.
+type QueryStatement[Param, Dest any] struct {
+	start     func(runner *Runner)
+	end       func(err error, runner *Runner)
+	pool      *sync.Pool
+	prepared  *preparedCache
+	validated *validatedCache
+	templates []string
+}
+
+// Templates returns the sorted names of the defined templates.
+func (qs *QueryStatement[Param, Dest]) Templates() []string {
+	return slices.Clone(qs.templates)
+}
+
+// Stats returns the Stats of the cache of prepared statements (see the Prepare option).
+func (qs *QueryStatement[Param, Dest]) Stats() Stats {
+	return qs.prepared.Stats()
+}
+
+// Close closes the cached prepared statements and forgets the validated sql. Statements in use are closed after their execution.
+// The QueryStatement can still be used afterwards.
+func (qs *QueryStatement[Param, Dest]) Close() error {
+	qs.validated.clear()
+
+	return qs.prepared.close()
+}
+
+// Get a QueryRunner from the pool and execute the start option.
+func (qs *QueryStatement[Param, Dest]) Get(ctx context.Context) *QueryRunner[Dest] {
+	runner := qs.pool.Get().(*QueryRunner[Dest])
+
+	runner.Runner.Context = ctx
+
+	runner.Runner.switchDialect()
+
+	runner.Runner.startTimeout()
+
+	runner.Runner.startReadOnly()
+
+	runner.Runner.startSpan()
+
+	if qs.start != nil {
+		qs.start(runner.Runner)
+	}
+
+	return runner
+}
+
+// Put a QueryRunner into the pool and execute the end option.
+func (qs *QueryStatement[Param, Dest]) Put(err error, runner *QueryRunner[Dest]) {
+	_ = qs.put(err, runner)
+}
+
+// put a QueryRunner into the pool and return the error transformed by the OnError option.
+func (qs *QueryStatement[Param, Dest]) put(err error, runner *QueryRunner[Dest]) error {
+	return qs.release(err, runner, true)
+}
+
+// release a QueryRunner into the pool. The OnError option is skipped, if transform is false,
+// e.g. because the error was already transformed when it was yielded by Iter.
+func (qs *QueryStatement[Param, Dest]) release(err error, runner *QueryRunner[Dest], transform bool) error {
+	if qs.end != nil {
+		qs.end(err, runner.Runner)
+	}
+
+	runner.Runner.endSpan(err)
+
+	if transform {
+		err = runner.Runner.onError(err)
+	}
+
+	if runner.Runner.cancel != nil {
+		runner.Runner.cancel()
+	}
+
+	runner.Reset()
+
+	qs.pool.Put(runner)
+
+	return err
+}
+
+// Render takes a runner and renders the Expression without executing it.
+func (qs *QueryStatement[Param, Dest]) Render(ctx context.Context, param Param) (expr Expression, err error) {
+	runner := qs.Get(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+
+		err = qs.put(err, runner)
+	}()
+
+	if err = runner.Runner.render(param); err != nil {
+		return expr, err
+	}
+
+	return Expression{
+		SQL:  runner.Runner.SQL.String(),
+		Args: slices.Clone(runner.Runner.Args),
+	}, nil
+}
+
+// Explain renders the sql, prefixes it with the dialect-specific EXPLAIN and returns the plan as text.
+// The 'Postgres' and 'MySQL' dialects use EXPLAIN ANALYZE, which executes the sql. 'SQLServer' is not supported
+// and other dialects use 'EXPLAIN QUERY PLAN'.
+func (qs *QueryStatement[Param, Dest]) Explain(ctx context.Context, db DB, param Param) (plan string, err error) {
+	runner := qs.Get(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+
+		err = qs.put(err, runner)
+	}()
+
+	if err = runner.Runner.render(param); err != nil {
+		return "", err
+	}
+
+	return runner.Runner.explain(db)
+}
+
+// renderZero renders the template with the zero value of Param, without executing the start, end and ValidateParam options.
+func (qs *QueryStatement[Param, Dest]) renderZero(ctx context.Context) (err error) {
+	runner := qs.pool.Get().(*QueryRunner[Dest])
+
+	runner.Runner.Context = ctx
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+
+		runner.Reset()
+
+		qs.pool.Put(runner)
+	}()
+
+	if err = runner.Runner.execute(runner.Runner.Template, *new(Param)); err != nil {
+		return err
+	}
+
+	return runner.Runner.finish()
+}
+
+// All returns a slice of Dest for each row.
+func (qs *QueryStatement[Param, Dest]) All(ctx context.Context, db DB, param Param) (result []Dest, err error) {
+	runner := qs.Get(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+
+		err = qs.put(err, runner)
+	}()
+
+	var rows *sql.Rows
+
+	rows, err = runner.Runner.Query(db, param)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(runner.Values) == 0 {
+		runner.Values = []any{runner.Dest}
+	}
+
+	defer func() {
+		err = errors.Join(err, rows.Close())
+	}()
+
+	for rows.Next() {
+		if err = rows.Scan(runner.Values...); err != nil {
+			return nil, err
+		}
+
+		if err = runner.mapRow(); err != nil {
+			return nil, err
+		}
+
+		result = append(result, *runner.Dest)
+	}
+
+	return result, err
+}
+
+// AllWithTotal returns all Dest and the total number of rows in a single round-trip. The last column of the query must be
+// the total count, e.g. 'SELECT {{ ScanString Dest.Title "title" }}, {{ TotalCount }} FROM books LIMIT 10'.
+// This requires support for window functions by the database. If there are no rows, the total is 0.
+func (qs *QueryStatement[Param, Dest]) AllWithTotal(ctx context.Context, db DB, param Param) (result []Dest, total int64, err error) {
+	runner := qs.Get(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+
+		err = qs.put(err, runner)
+	}()
+
+	var rows *sql.Rows
+
+	rows, err = runner.Runner.Query(db, param)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(runner.Values) == 0 {
+		runner.Values = []any{runner.Dest}
+	}
+
+	values := append(slices.Clip(runner.Values), &total)
+
+	defer func() {
+		err = errors.Join(err, rows.Close())
+	}()
+
+	for rows.Next() {
+		if err = rows.Scan(values...); err != nil {
+			return nil, 0, err
+		}
+
+		if err = runner.mapRow(); err != nil {
+			return nil, 0, err
+		}
+
+		result = append(result, *runner.Dest)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return result, total, nil
+}
+
+// EncodeJSON streams the rows mapped into Dest as a JSON array to w, e.g. for large results of HTTP endpoints.
+// An empty result is written as '[]'. Errors after the first write leave the array incomplete.
+func (qs *QueryStatement[Param, Dest]) EncodeJSON(ctx context.Context, db DB, param Param, w io.Writer) (err error) {
+	runner := qs.Get(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+
+		err = qs.put(err, runner)
+	}()
+
+	var rows *sql.Rows
+
+	rows, err = runner.Runner.Query(db, param)
+	if err != nil {
+		return err
+	}
+
+	if len(runner.Values) == 0 {
+		runner.Values = []any{runner.Dest}
+	}
+
+	defer func() {
+		err = errors.Join(err, rows.Close())
+	}()
+
+	sep := "["
+
+	for rows.Next() {
+		if err = runner.scan(rows); err != nil {
+			return err
+		}
+
+		var data []byte
+
+		data, err = json.Marshal(runner.Dest)
+		if err != nil {
+			return err
+		}
+
+		if _, err = io.WriteString(w, sep); err != nil {
+			return err
+		}
+
+		if _, err = w.Write(data); err != nil {
+			return err
+		}
+
+		sep = ","
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if sep == "[" {
+		_, err = io.WriteString(w, "[]")
+	} else {
+		_, err = io.WriteString(w, "]")
+	}
+
+	return err
+}
+
+// ExecReturning executes a mutation with a RETURNING clause (e.g. 'INSERT ... RETURNING id') and maps the returned rows.
+// The affected count is the number of returned rows.
+func (qs *QueryStatement[Param, Dest]) ExecReturning(ctx context.Context, db DB, param Param) ([]Dest, int64, error) {
+	result, err := qs.All(ctx, db, param)
+
+	return result, int64(len(result)), err
+}
+
+// Returned are the rows returned by a mutation with a RETURNING clause and the number of affected rows.
+type Returned[Dest any] struct {
+	Rows     []Dest
+	Affected int64
+}
+
+// ExecReturned is like ExecReturning, but returns the rows and the affected count as Returned.
+func (qs *QueryStatement[Param, Dest]) ExecReturned(ctx context.Context, db DB, param Param) (Returned[Dest], error) {
+	rows, affected, err := qs.ExecReturning(ctx, db, param)
+	if err != nil {
+		return Returned[Dest]{}, err
+	}
+
+	return Returned[Dest]{Rows: rows, Affected: affected}, nil
+}
+
+// Custom queries rows and passes them to do, e.g. to inspect the ColumnTypes in a custom scan loop.
+// scan maps the current row into Dest using the Scanners of the template. The rows are closed afterwards.
+func (qs *QueryStatement[Param, Dest]) Custom(ctx context.Context, db DB, param Param, do func(rows *sql.Rows, scan func() (Dest, error)) error) (err error) {
+	runner := qs.Get(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+
+		err = qs.put(err, runner)
+	}()
+
+	var rows *sql.Rows
+
+	rows, err = runner.Runner.Query(db, param)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err = errors.Join(err, rows.Close())
+	}()
+
+	if len(runner.Values) == 0 {
+		runner.Values = []any{runner.Dest}
+	}
+
+	if err = do(rows, func() (Dest, error) {
+		if err := runner.scan(rows); err != nil {
+			return *new(Dest), err
+		}
+
+		return *runner.Dest, nil
+	}); err != nil {
+		return err
+	}
+
+	return rows.Err()
+}
+
+// Page is a page of a result set and the total number of rows.
+type Page[Dest any] struct {
+	Items   []Dest
+	Total   int64
+	HasMore bool
+}
+
+// AllPage returns a Page of Dest. The template must define a sub-template 'count' selecting the total number of rows,
+// e.g. '{{ define "count" }}SELECT COUNT(*) FROM books{{ end }}'. 'LIMIT ... OFFSET ...' is appended to the main query.
+func (qs *QueryStatement[Param, Dest]) AllPage(ctx context.Context, db DB, param Param, limit, offset int64) (page Page[Dest], err error) {
+	runner := qs.Get(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+
+		err = qs.put(err, runner)
+	}()
+
+	count := runner.Runner.Template.Lookup("count")
+	if count == nil {
+		return page, errors.New("template: no 'count' template defined")
+	}
+
+	if err = runner.Runner.validateParam(param); err != nil {
+		return page, err
+	}
+
+	if err = runner.Runner.execute(count, param); err != nil {
+		return page, err
+	}
+
+	if err = runner.Runner.finish(); err != nil {
+		return page, err
+	}
+
+	var row *sql.Row
+
+	row, err = runner.Runner.queryRow(db)
+	if err != nil {
+		return page, err
+	}
+
+	if err = row.Scan(&page.Total); err != nil {
+		return page, err
+	}
+
+	runner.Runner.SQL.Reset()
+	runner.Runner.Args = runner.Runner.Args[:0]
+	runner.Values = runner.Values[:0]
+	runner.Mappers = runner.Mappers[:0]
+	runner.columns = runner.columns[:0]
+
+	if err = runner.Runner.execute(runner.Runner.Template, param); err != nil {
+		return page, err
+	}
+
+	var raw Raw
+
+	raw, err = runner.Runner.limitOffset(limit, offset)
+	if err != nil {
+		return page, err
+	}
+
+	_, _ = runner.Runner.SQL.Write([]byte(" " + raw))
+
+	if err = runner.Runner.finish(); err != nil {
+		return page, err
+	}
+
+	var rows *sql.Rows
+
+	rows, err = runner.Runner.query(db)
+	if err != nil {
+		return page, err
+	}
+
+	defer func() {
+		err = errors.Join(err, rows.Close())
+	}()
+
+	if len(runner.Values) == 0 {
+		runner.Values = []any{runner.Dest}
+	}
+
+	for rows.Next() {
+		if err = runner.scan(rows); err != nil {
+			return page, err
+		}
+
+		page.Items = append(page.Items, *runner.Dest)
+	}
+
+	if err = rows.Err(); err != nil {
+		return page, err
+	}
+
+	page.HasMore = offset+int64(len(page.Items)) < page.Total
+
+	return page, nil
+}
+
+// Iter returns an iterator that yields a Dest for each row without buffering the result set.
+// The rows are closed and the QueryRunner is put back into the pool, when the iteration is completed or stopped.
+func (qs *QueryStatement[Param, Dest]) Iter(ctx context.Context, db DB, param Param) iter.Seq2[Dest, error] {
+	return func(yield func(Dest, error) bool) {
+		var (
+			err      error
+			yielding bool
+		)
+
+		runner := qs.Get(ctx)
+
+		// panics of the loop body are not recovered, but the runner is released anyway.
+		emit := func(dest Dest, err error) bool {
+			yielding = true
+
+			ok := yield(dest, err)
+
+			yielding = false
+
+			return ok
+		}
+
+		defer func() {
+			if !yielding {
+				if r := recover(); r != nil {
+					err = errors.Join(err, toErr(r))
+
+					emit(*new(Dest), runner.Runner.onError(err))
+				}
+			}
+
+			_ = qs.release(err, runner, false)
+		}()
+
+		var rows *sql.Rows
+
+		rows, err = runner.Runner.Query(db, param)
+		if err != nil {
+			emit(*new(Dest), runner.Runner.onError(err))
+
+			return
+		}
+
+		defer func() {
+			err = errors.Join(err, rows.Close())
+		}()
+
+		if len(runner.Values) == 0 {
+			runner.Values = []any{runner.Dest}
+		}
+
+		for rows.Next() {
+			if err = runner.scan(rows); err != nil {
+				emit(*new(Dest), runner.Runner.onError(err))
+
+				return
+			}
+
+			if !emit(*runner.Dest, nil) {
+				return
+			}
+		}
+
+		if err = errors.Join(rows.Err(), rows.Close()); err != nil {
+			emit(*new(Dest), runner.Runner.onError(err))
+		}
+	}
+}
+
+// scan the current row into the values, execute the mappers and recover from panics.
+func (qr *QueryRunner[Dest]) scan(rows *sql.Rows) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+	}()
+
+	if err = rows.Scan(qr.Values...); err != nil {
+		return err
+	}
+
+	return qr.mapRow()
+}
+
+// mapRow executes the mappers of the scanned row and the MapRow option. Errors are wrapped with the location and the column.
+func (qr *QueryRunner[Dest]) mapRow() error {
+	for i, m := range qr.Mappers {
+		if m == nil {
+			continue
+		}
+
+		if err := m(); err != nil {
+			return fmt.Errorf("location: [%s]: column '%s': %w", qr.Runner.Location, qr.columns[i], err)
+		}
+	}
+
+	if qr.Runner.config.MapRow != nil {
+		if err := qr.Runner.config.MapRow(qr.Dest); err != nil {
+			return fmt.Errorf("location: [%s]: %w", qr.Runner.Location, err)
+		}
+	}
+
+	return nil
+}
+
+// ErrNotFound is returned from First, when there is no row. It wraps sql.ErrNoRows.
+var ErrNotFound = fmt.Errorf("not found: %w", sql.ErrNoRows)
+
+// ErrTooManyRows is returned from One, when there are more than one rows.
+var ErrTooManyRows = errors.New("too many rows")
+
+// One returns exactly one Dest. If there is more than one row in the result set, ErrTooManyRows is returned.
+func (qs *QueryStatement[Param, Dest]) One(ctx context.Context, db DB, param Param) (result Dest, err error) {
+	runner := qs.Get(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+
+		err = qs.put(err, runner)
+	}()
+
+	var rows *sql.Rows
+
+	rows, err = runner.Runner.Query(db, param)
+	if err != nil {
+		return *runner.Dest, err
+	}
+
+	if len(runner.Values) == 0 {
+		runner.Values = []any{runner.Dest}
+	}
+
+	defer func() {
+		err = errors.Join(err, rows.Close())
+	}()
+
+	if !rows.Next() {
+		return *runner.Dest, sql.ErrNoRows
+	}
+
+	err = rows.Scan(runner.Values...)
+	if err != nil {
+		return *runner.Dest, err
+	}
+
+	if err = runner.mapRow(); err != nil {
+		return *runner.Dest, err
+	}
+
+	if rows.Next() {
+		return *runner.Dest, ErrTooManyRows
+	}
+
+	return *runner.Dest, err
+}
+
+// First returns the first row mapped into Dest. If there is no row, ErrNotFound is returned.
+func (qs *QueryStatement[Param, Dest]) First(ctx context.Context, db DB, param Param) (result Dest, err error) {
+	runner := qs.Get(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Join(err, toErr(r))
+		}
+
+		err = qs.put(err, runner)
+	}()
+
+	var row *sql.Row
+
+	row, err = runner.Runner.QueryRow(db, param)
+	if err != nil {
+		return *runner.Dest, err
+	}
+
+	if len(runner.Values) == 0 {
+		runner.Values = []any{runner.Dest}
+	}
+
+	if err = row.Scan(runner.Values...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return *runner.Dest, ErrNotFound
+		}
+
+		return *runner.Dest, err
+	}
+
+	if err = runner.mapRow(); err != nil {
+		return *runner.Dest, err
+	}
+
+	return *runner.Dest, nil
+}
+
+// ErrDuplicateKey is returned from AllByUnique, when two rows have the same key.
+var ErrDuplicateKey = errors.New("duplicate key")
+
+// AllBy returns all Dest keyed by key, e.g. for lookups by id. Rows with duplicate keys overwrite previous rows.
+func AllBy[K comparable, Param, Dest any](ctx context.Context, qs *QueryStatement[Param, Dest], db DB, param Param, key func(Dest) K) (map[K]Dest, error) {
+	return allBy(ctx, qs, db, param, key, false)
+}
+
+// AllByUnique is like AllBy, but returns ErrDuplicateKey, if two rows have the same key.
+func AllByUnique[K comparable, Param, Dest any](ctx context.Context, qs *QueryStatement[Param, Dest], db DB, param Param, key func(Dest) K) (map[K]Dest, error) {
+	return allBy(ctx, qs, db, param, key, true)
+}
+
+func allBy[K comparable, Param, Dest any](ctx context.Context, qs *QueryStatement[Param, Dest], db DB, param Param, key func(Dest) K, unique bool) (map[K]Dest, error) {
+	list, err := qs.All(ctx, db, param)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[K]Dest, len(list))
+
+	for _, dest := range list {
+		k := key(dest)
+
+		if _, ok := result[k]; ok && unique {
+			return nil, fmt.Errorf("%w: %v", ErrDuplicateKey, k)
+		}
+
+		result[k] = dest
+	}
+
+	return result, nil
+}
+
+// GroupBy returns all Dest grouped by key, e.g. the rows of a one-to-many join grouped by the parent id.
+// The order of the rows is kept within each group. Like All, it buffers the whole result set in memory.
+func GroupBy[K comparable, Param, Dest any](ctx context.Context, qs *QueryStatement[Param, Dest], db DB, param Param, key func(Dest) K) (map[K][]Dest, error) {
+	list, err := qs.All(ctx, db, param)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[K][]Dest{}
+
+	for _, dest := range list {
+		k := key(dest)
+
+		result[k] = append(result[k], dest)
+	}
+
+	return result, nil
+}
+
+// SQL implements io.Writer and fmt.Stringer.
+// Whitespace is collapsed into single spaces, unless the PreserveWhitespace option is set.
+// String literals, quoted identifiers ("...", `...` and [...]) and comments are written verbatim,
+// even across multiple writes. Writes fail, if the data exceeds the MaxSQLSize option.
+type SQL struct {
+	data     []byte
+	preserve bool
+	compact  bool
+	state    sqlState
+	prev     byte
+	max      int
+}
+
+// sqlState is the lexical state of the sql written so far.
+type sqlState uint8
+
+const (
+	stateNormal sqlState = iota
+	stateLiteral
+	stateDoubleQuoted
+	stateBacktick
+	stateBracket
+	stateLineComment
+	stateBlockComment
+)
+
+// closingQuotes are the bytes ending the quoted states.
+var closingQuotes = map[sqlState]byte{
+	stateLiteral:      '\'',
+	stateDoubleQuoted: '"',
+	stateBacktick:     '`',
+	stateBracket:      ']',
+}
+
+// Reset the internal byte slice.
+func (w *SQL) Reset() {
+	w.data = w.data[:0]
+	w.state = stateNormal
+	w.prev = 0
+}
+
+// next updates the lexical state by the byte b and reports whether b is written verbatim.
+// Doubled quotes (”) end and reopen the literal.
+func (w *SQL) next(b byte) bool {
+	prev := w.prev
+	w.prev = b
+
+	switch w.state {
+	case stateLiteral, stateDoubleQuoted, stateBacktick, stateBracket:
+		if b == closingQuotes[w.state] {
+			w.state = stateNormal
+		}
+
+		return true
+	case stateLineComment:
+		if b == '\n' {
+			w.state = stateNormal
+		}
+
+		return true
+	case stateBlockComment:
+		if b == '/' && prev == '*' {
+			w.state = stateNormal
+			w.prev = 0
+		}
+
+		return true
+	}
+
+	switch {
+	case b == '\'':
+		w.state = stateLiteral
+	case b == '"':
+		w.state = stateDoubleQuoted
+	case b == '`':
+		w.state = stateBacktick
+	case b == '[':
+		w.state = stateBracket
+	case b == '-' && prev == '-':
+		w.state = stateLineComment
+	case b == '*' && prev == '/':
+		w.state = stateBlockComment
+		// the opening '*' must not close the comment ('/*/').
+		w.prev = 0
+	}
+
+	return w.state != stateNormal
+}
+
+// Write implements the io.Writer interface.
+func (w *SQL) Write(data []byte) (int, error) {
+	if w.preserve {
+		w.data = append(w.data, data...)
+
+		return len(data), w.checkSize()
+	}
+
+	for _, b := range data {
+		if w.next(b) {
+			w.data = append(w.data, b)
+
+			continue
+		}
+
+		switch b {
+		case ' ', '\n', '\r', '\t':
+			if len(w.data) > 0 && w.data[len(w.data)-1] != ' ' && (!w.compact || w.data[len(w.data)-1] != '(') {
+				w.data = append(w.data, ' ')
+			}
+		case ')':
+			if w.compact && len(w.data) > 0 && w.data[len(w.data)-1] == ' ' {
+				w.data = w.data[:len(w.data)-1]
+			}
+
+			w.data = append(w.data, b)
+		default:
+			w.data = append(w.data, b)
+		}
+	}
+
+	return len(data), w.checkSize()
+}
+
+// checkSize returns an error, if the data exceeds the maximum size.
+func (w *SQL) checkSize() error {
+	if w.max > 0 && len(w.data) > w.max {
+		return fmt.Errorf("rendered sql exceeds %d bytes", w.max)
+	}
+
+	return nil
+}
+
+// String implements the fmt.Stringer interface.
+func (w *SQL) String() string {
+	if len(w.data) == 0 {
+		return ""
+	}
+
+	if !w.preserve && w.state == stateNormal && w.data[len(w.data)-1] == ' ' {
+		w.data = w.data[:len(w.data)-1]
+	}
+
+	return string(w.data)
+}
+
+// FormatSQL formats sql for logging by starting a new line before the main keywords (SELECT, FROM, WHERE, JOIN, ...)
+// and indenting AND and OR. It is a simple keyword-based formatter and not a sql parser.
+// Keywords inside parentheses and string literals are kept as is. Do not execute the formatted sql.
+func FormatSQL(str string) string {
+	var (
+		tokens []string
+		depths []int
+		token  strings.Builder
+		quoted bool
+		depth  int
+		start  int
+	)
+
+	flush := func() {
+		if token.Len() > 0 {
+			tokens = append(tokens, token.String())
+			depths = append(depths, start)
+			token.Reset()
+		}
+	}
+
+	for _, r := range str {
+		if token.Len() == 0 {
+			start = depth
+		}
+
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case quoted:
+		case unicode.IsSpace(r):
+			flush()
+
+			continue
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		}
+
+		token.WriteRune(r)
+	}
+
+	flush()
+
+	var sb strings.Builder
+
+	for i, token := range tokens {
+		var next string
+
+		if i+1 < len(tokens) {
+			next = strings.ToUpper(tokens[i+1])
+		}
+
+		var prev string
+
+		if i > 0 {
+			prev = strings.ToUpper(tokens[i-1])
+		}
+
+		sep := " "
+
+		if depths[i] == 0 {
+			switch strings.ToUpper(token) {
+			case "SELECT", "FROM", "WHERE", "HAVING", "LIMIT", "OFFSET", "UNION", "VALUES", "SET", "RETURNING",
+				"INSERT", "UPDATE", "DELETE", "WITH":
+				sep = "\n"
+			case "GROUP", "ORDER":
+				if next == "BY" {
+					sep = "\n"
+				}
+			case "LEFT", "RIGHT", "INNER", "FULL", "CROSS":
+				if next == "JOIN" || next == "OUTER" {
+					sep = "\n"
+				}
+			case "JOIN":
+				if prev != "LEFT" && prev != "RIGHT" && prev != "INNER" && prev != "FULL" && prev != "CROSS" && prev != "OUTER" {
+					sep = "\n"
+				}
+			case "AND", "OR":
+				if prev != "BETWEEN" {
+					sep = "\n  "
+				}
+			}
+		}
+
+		if i > 0 {
+			sb.WriteString(sep)
+		}
+
+		sb.WriteString(token)
+	}
+
+	return sb.String()
+}
+
+var ident = "___sqlt___"
+
+// include is the name of the template, that the TemplateOptions of Include are applied to.
+var include = "___sqlt_include___"
+
+// copied from here: https://github.com/mhilton/sqltemplate/blob/main/escape.go
+func escape(text *template.Template) {
+	for _, tpl := range text.Templates() {
+		if tpl.Tree.Root != nil {
+			escapeNode(tpl.Tree, tpl.Tree.Root)
+		}
+	}
+}
+
+func escapeNode(s *parse.Tree, n parse.Node) {
+	switch v := n.(type) {
+	case *parse.ActionNode:
+		escapeNode(s, v.Pipe)
+	case *parse.IfNode:
+		escapeNode(s, v.List)
+		escapeNode(s, v.ElseList)
+	case *parse.ListNode:
+		if v == nil {
+			return
+		}
+
+		for _, n := range v.Nodes {
+			escapeNode(s, n)
+		}
+	case *parse.PipeNode:
+		if len(v.Decl) > 0 {
+			return
+		}
+
+		if len(v.Cmds) < 1 {
+			return
+		}
+
+		cmd := v.Cmds[len(v.Cmds)-1]
+		if len(cmd.Args) == 1 && cmd.Args[0].Type() == parse.NodeIdentifier && cmd.Args[0].(*parse.IdentifierNode).Ident == ident {
+			return
+		}
+
+		v.Cmds = append(v.Cmds, &parse.CommandNode{
+			NodeType: parse.NodeCommand,
+			Args:     []parse.Node{parse.NewIdentifier(ident).SetTree(s).SetPos(cmd.Pos)},
+		})
+	case *parse.RangeNode:
+		escapeNode(s, v.List)
+		escapeNode(s, v.ElseList)
+	case *parse.WithNode:
+		escapeNode(s, v.List)
+		escapeNode(s, v.ElseList)
+	}
+}
+
+// copied from the text/template package.
+func goodName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_':
+		case i == 0 && !unicode.IsLetter(r):
+			return false
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			return false
+		}
+	}
+	return true
+}
+
+// checkTemplates reports all references to undefined templates and all type errors together.
+// templatecheck stops at the first error, so the failing node is removed from a copy of the templates
+// and the check is repeated (see pruneNode).
+func checkTemplates(tpl *template.Template, param any) error {
+	var errs []error
+
+	undefined := undefinedTemplates(tpl)
+
+	for _, name := range undefined {
+		errs = append(errs, fmt.Errorf("template '%s' is not defined", name))
+	}
+
+	check, err := copyTemplates(tpl, undefined)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+
+	for {
+		err = templatecheck.CheckText(check, param)
+		if err == nil {
+			break
+		}
+
+		errs = append(errs, err)
+
+		if check.Tree == nil || !pruneList(check, param, check.Tree.Root, map[string]bool{}) {
+			break
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// copyTemplates returns a clone of tpl with copied parse trees and empty definitions for the undefined templates.
+func copyTemplates(tpl *template.Template, undefined []string) (*template.Template, error) {
+	check, err := tpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range check.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+
+		if _, err = check.AddParseTree(t.Name(), t.Tree.Copy()); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range undefined {
+		if _, err = check.New(name).Parse(""); err != nil {
+			return nil, err
+		}
+	}
+
+	return check, nil
+}
+
+// pruneList removes the first node of list that fails the check and reports whether a node was removed.
+// Nested lists and invoked templates are searched first, so that only the innermost failing node is removed.
+func pruneList(check *template.Template, param any, list *parse.ListNode, visited map[string]bool) bool {
+	if list == nil || len(list.Nodes) == 0 {
+		return false
+	}
+
+	nodes := list.Nodes
+
+	list.Nodes = nil
+
+	if templatecheck.CheckText(check, param) != nil {
+		list.Nodes = nodes
+
+		return false
+	}
+
+	for i := range nodes {
+		list.Nodes = nodes[:i+1]
+
+		if templatecheck.CheckText(check, param) == nil {
+			continue
+		}
+
+		list.Nodes = nodes
+
+		if pruneNode(check, param, nodes[i], visited) {
+			return true
+		}
+
+		list.Nodes = slices.Delete(slices.Clone(nodes), i, i+1)
+
+		return true
+	}
+
+	list.Nodes = nodes
+
+	return false
+}
+
+// pruneNode searches the lists of n and the template invoked by n for a failing node.
+func pruneNode(check *template.Template, param any, n parse.Node, visited map[string]bool) bool {
+	switch v := n.(type) {
+	case *parse.IfNode:
+		return pruneList(check, param, v.List, visited) || pruneList(check, param, v.ElseList, visited)
+	case *parse.RangeNode:
+		return pruneList(check, param, v.List, visited) || pruneList(check, param, v.ElseList, visited)
+	case *parse.WithNode:
+		return pruneList(check, param, v.List, visited) || pruneList(check, param, v.ElseList, visited)
+	case *parse.TemplateNode:
+		t := check.Lookup(v.Name)
+		if t == nil || t.Tree == nil || visited[v.Name] {
+			return false
+		}
+
+		visited[v.Name] = true
+
+		return pruneList(check, param, t.Tree.Root, visited)
+	}
+
+	return false
+}
+
+// undefinedTemplates returns the sorted names of the templates, that are invoked but not defined.
+func undefinedTemplates(tpl *template.Template) []string {
+	var names []string
+
+	for _, t := range tpl.Templates() {
+		if t.Tree == nil || t.Tree.Root == nil {
+			continue
+		}
+
+		collectTemplates(t.Tree.Root, func(name string) {
+			if tpl.Lookup(name) == nil && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		})
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// collectTemplates calls add for each template invoked in n.
+func collectTemplates(n parse.Node, add func(name string)) {
+	switch v := n.(type) {
+	case *parse.ListNode:
+		if v == nil {
+			return
+		}
+
+		for _, n := range v.Nodes {
+			collectTemplates(n, add)
+		}
+	case *parse.IfNode:
+		collectTemplates(v.List, add)
+		collectTemplates(v.ElseList, add)
+	case *parse.RangeNode:
+		collectTemplates(v.List, add)
+		collectTemplates(v.ElseList, add)
+	case *parse.WithNode:
+		collectTemplates(v.List, add)
+		collectTemplates(v.ElseList, add)
+	case *parse.TemplateNode:
+		add(v.Name)
+	}
+}
+
+// checkScanAll validates the calls of ScanAll with a constant list of columns on the template functions names
+// returning dest (e.g. 'ScanAll Dest "id, title"'), so that missing fields are reported on construction.
+func checkScanAll(tpl *template.Template, dest any, fold bool, names ...string) error {
+	var errs []error
+
+	for _, t := range tpl.Templates() {
+		if t.Tree == nil || t.Tree.Root == nil {
+			continue
+		}
+
+		collectCommands(t.Tree.Root, func(cmd *parse.CommandNode) {
+			if len(cmd.Args) != 3 {
+				return
+			}
+
+			if fn, ok := cmd.Args[0].(*parse.IdentifierNode); !ok || fn.Ident != "ScanAll" {
+				return
+			}
+
+			if id, ok := cmd.Args[1].(*parse.IdentifierNode); !ok || !slices.Contains(names, id.Ident) {
+				return
+			}
+
+			columns, ok := cmd.Args[2].(*parse.StringNode)
+			if !ok {
+				return
+			}
+
+			if _, err := scanAll(dest, columns.Text, fold); err != nil {
+				location, _ := t.ErrorContext(cmd)
+
+				errs = append(errs, fmt.Errorf("template: %s: %w", location, err))
+			}
+		})
+	}
+
+	return errors.Join(errs...)
+}
+
+// collectCommands calls add for each command in n.
+func collectCommands(n parse.Node, add func(cmd *parse.CommandNode)) {
+	switch v := n.(type) {
+	case *parse.ListNode:
+		if v == nil {
+			return
+		}
+
+		for _, n := range v.Nodes {
+			collectCommands(n, add)
+		}
+	case *parse.ActionNode:
+		collectCommands(v.Pipe, add)
+	case *parse.IfNode:
+		collectCommands(v.Pipe, add)
+		collectCommands(v.List, add)
+		collectCommands(v.ElseList, add)
+	case *parse.RangeNode:
+		collectCommands(v.Pipe, add)
+		collectCommands(v.List, add)
+		collectCommands(v.ElseList, add)
+	case *parse.WithNode:
+		collectCommands(v.Pipe, add)
+		collectCommands(v.List, add)
+		collectCommands(v.ElseList, add)
+	case *parse.TemplateNode:
+		collectCommands(v.Pipe, add)
+	case *parse.PipeNode:
+		if v == nil {
+			return
+		}
 
-	runner.Runner.Context = ctx
+		for _, cmd := range v.Cmds {
+			collectCommands(cmd, add)
+		}
+	case *parse.CommandNode:
+		add(v)
 
-	if qs.start != nil {
-		qs.start(runner.Runner)
+		for _, arg := range v.Args {
+			collectCommands(arg, add)
+		}
 	}
-
-	return runner
 }
 
-// Put a QueryRunner into the pool and execute the end option.
-func (qs *QueryStatement[Param, Dest]) Put(err error, runner *QueryRunner[Dest]) {
-	if qs.end != nil {
-		qs.end(err, runner.Runner)
+// requireAllFields returns an error, if an exported field of the struct dest is not used in the templates.
+// names are the template functions returning dest.
+func requireAllFields(tpl *template.Template, dest reflect.Type, names ...string) error {
+	if dest.Kind() != reflect.Struct {
+		return nil
 	}
 
-	runner.Reset()
-
-	qs.pool.Put(runner)
-}
+	used := map[string]bool{}
 
-// All returns a slice of Dest for each row.
-func (qs *QueryStatement[Param, Dest]) All(ctx context.Context, db DB, param Param) (result []Dest, err error) {
-	runner := qs.Get(ctx)
+	for _, t := range tpl.Templates() {
+		if t.Tree == nil || t.Tree.Root == nil {
+			continue
+		}
 
-	defer func() {
-		if r := recover(); r != nil {
-			err = errors.Join(err, toErr(r))
+		if !collectFields(t.Tree.Root, names, used) {
+			return nil
 		}
+	}
 
-		qs.Put(err, runner)
-	}()
+	var missing []string
 
-	var rows *sql.Rows
+	for i := range dest.NumField() {
+		field := dest.Field(i)
 
-	rows, err = runner.Runner.Query(db, param)
-	if err != nil {
-		return nil, err
+		if field.IsExported() && field.Tag.Get("sqlt") != "optional" && !used[field.Name] {
+			missing = append(missing, field.Name)
+		}
 	}
 
-	if len(runner.Values) == 0 {
-		runner.Values = []any{runner.Dest}
+	if len(missing) > 0 {
+		return fmt.Errorf("fields of %s are not used: %s", dest, strings.Join(missing, ", "))
 	}
 
-	defer func() {
-		err = errors.Join(err, rows.Close())
-	}()
+	return nil
+}
 
-	for rows.Next() {
-		if err = rows.Scan(runner.Values...); err != nil {
-			return nil, err
+// collectFields records the fields accessed on the template functions names (e.g. 'Dest.Title').
+// It returns false, if one of the functions is used as a whole.
+func collectFields(n parse.Node, names []string, used map[string]bool) bool {
+	switch v := n.(type) {
+	case *parse.ListNode:
+		if v == nil {
+			return true
 		}
 
-		for _, m := range runner.Mappers {
-			if m == nil {
-				continue
+		for _, n := range v.Nodes {
+			if !collectFields(n, names, used) {
+				return false
 			}
+		}
+	case *parse.ActionNode:
+		return collectFields(v.Pipe, names, used)
+	case *parse.IfNode:
+		return collectFields(v.Pipe, names, used) && collectFields(v.List, names, used) && collectFields(v.ElseList, names, used)
+	case *parse.RangeNode:
+		return collectFields(v.Pipe, names, used) && collectFields(v.List, names, used) && collectFields(v.ElseList, names, used)
+	case *parse.WithNode:
+		return collectFields(v.Pipe, names, used) && collectFields(v.List, names, used) && collectFields(v.ElseList, names, used)
+	case *parse.TemplateNode:
+		return collectFields(v.Pipe, names, used)
+	case *parse.PipeNode:
+		if v == nil {
+			return true
+		}
 
-			if err = m(); err != nil {
-				return nil, err
+		for _, cmd := range v.Cmds {
+			if !collectFields(cmd, names, used) {
+				return false
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range v.Args {
+			if !collectFields(arg, names, used) {
+				return false
+			}
+		}
+	case *parse.ChainNode:
+		if id, ok := v.Node.(*parse.IdentifierNode); ok && slices.Contains(names, id.Ident) {
+			if len(v.Field) > 0 {
+				used[v.Field[0]] = true
 			}
+
+			return true
 		}
 
-		result = append(result, *runner.Dest)
+		return collectFields(v.Node, names, used)
+	case *parse.IdentifierNode:
+		return !slices.Contains(names, v.Ident)
 	}
 
-	return result, err
+	return true
 }
 
-// ErrTooManyRows is returned from One, when there are more than one rows.
-var ErrTooManyRows = errors.New("too many rows")
-
-// One returns exactly one Dest. If there is more than one row in the result set, ErrTooManyRows is returned.
-func (qs *QueryStatement[Param, Dest]) One(ctx context.Context, db DB, param Param) (result Dest, err error) {
-	runner := qs.Get(ctx)
+// validIdent reports whether name is a plain (optionally schema-qualified) sql identifier.
+func validIdent(name string) bool {
+	if name == "" {
+		return false
+	}
 
-	defer func() {
-		if r := recover(); r != nil {
-			err = errors.Join(err, toErr(r))
+	for _, part := range strings.Split(name, ".") {
+		if !goodName(part) {
+			return false
 		}
+	}
 
-		qs.Put(err, runner)
-	}()
+	return true
+}
 
-	var rows *sql.Rows
+// keyset emits a predicate to select the rows after the cursor. The cursor is a slice or a struct,
+// whose exported fields correspond to the columns. Columns can override the direction using the suffix ' ASC' or ' DESC'.
+// Row-value comparison is used for Postgres, MySQL and Sqlite, if all columns have the same direction.
+func keyset(direction string, cursor any, columns ...string) (RunnerFunc, error) {
+	var values []any
 
-	rows, err = runner.Runner.Query(db, param)
-	if err != nil {
-		return *runner.Dest, err
+	value := reflect.Indirect(reflect.ValueOf(cursor))
+
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := range value.Len() {
+			values = append(values, value.Index(i).Interface())
+		}
+	case reflect.Struct:
+		for i := range value.NumField() {
+			if value.Type().Field(i).IsExported() {
+				values = append(values, value.Field(i).Interface())
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid cursor type %T: expected slice or struct", cursor)
 	}
 
-	if len(runner.Values) == 0 {
-		runner.Values = []any{runner.Dest}
+	if len(columns) == 0 || len(values) != len(columns) {
+		return nil, fmt.Errorf("cursor has %d values for %d columns", len(values), len(columns))
 	}
 
-	defer func() {
-		err = errors.Join(err, rows.Close())
-	}()
+	var (
+		names = make([]string, len(columns))
+		ops   = make([]string, len(columns))
+		mixed bool
+	)
 
-	if !rows.Next() {
-		return *runner.Dest, sql.ErrNoRows
-	}
+	for i, column := range columns {
+		dir := direction
 
-	err = rows.Scan(runner.Values...)
-	if err != nil {
-		return *runner.Dest, err
-	}
+		if name, suffix, ok := strings.Cut(column, " "); ok {
+			column, dir = name, suffix
+		}
 
-	for _, m := range runner.Mappers {
-		if m == nil {
-			continue
+		switch strings.ToUpper(dir) {
+		case "ASC":
+			ops[i] = ">"
+		case "DESC":
+			ops[i] = "<"
+		default:
+			return nil, fmt.Errorf("invalid direction '%s'", dir)
 		}
 
-		if err = m(); err != nil {
-			return *runner.Dest, err
+		if !validIdent(column) {
+			return nil, fmt.Errorf("invalid column name '%s'", column)
 		}
-	}
 
-	if rows.Next() {
-		return *runner.Dest, ErrTooManyRows
+		names[i] = column
+		mixed = mixed || ops[i] != ops[0]
 	}
 
-	return *runner.Dest, err
-}
+	return func(runner *Runner) (Raw, error) {
+		switch runner.config.Dialect {
+		case "Postgres", "MySQL", "Sqlite":
+			if !mixed {
+				placeholders := make([]string, len(values))
 
-// First returns the first row mapped into Dest.
-func (qs *QueryStatement[Param, Dest]) First(ctx context.Context, db DB, param Param) (result Dest, err error) {
-	runner := qs.Get(ctx)
+				for i, v := range values {
+					placeholders[i] = string(runner.Bind(v))
+				}
 
-	defer func() {
-		if r := recover(); r != nil {
-			err = errors.Join(err, toErr(r))
+				return Raw(fmt.Sprintf("(%s) %s (%s)", strings.Join(names, ", "), ops[0], strings.Join(placeholders, ", "))), nil
+			}
 		}
 
-		qs.Put(err, runner)
-	}()
-
-	var row *sql.Row
-
-	row, err = runner.Runner.QueryRow(db, param)
-	if err != nil {
-		return *runner.Dest, err
-	}
+		predicates := make([]string, len(names))
 
-	if len(runner.Values) == 0 {
-		runner.Values = []any{runner.Dest}
-	}
+		for i := range names {
+			parts := make([]string, 0, i+1)
 
-	if err = row.Scan(runner.Values...); err != nil {
-		return *runner.Dest, err
-	}
+			for j := range i {
+				parts = append(parts, names[j]+" = "+string(runner.Bind(values[j])))
+			}
 
-	for _, m := range runner.Mappers {
-		if m == nil {
-			continue
-		}
+			parts = append(parts, names[i]+" "+ops[i]+" "+string(runner.Bind(values[i])))
 
-		if err = m(); err != nil {
-			return *runner.Dest, err
+			predicates[i] = "(" + strings.Join(parts, " AND ") + ")"
 		}
-	}
 
-	return *runner.Dest, nil
+		return Raw("(" + strings.Join(predicates, " OR ") + ")"), nil
+	}, nil
 }
 
-// SQL implements io.Writer and fmt.Stringer.
-type SQL struct {
-	data []byte
-}
+// clampLimit binds the requested limit clamped to maximum, e.g. 'LIMIT {{ Limit .PageSize 100 20 }}'.
+// requested is an integer or a pointer to an integer. Missing and non-positive limits are replaced by fallback.
+func clampLimit(requested any, maximum, fallback int64) (RunnerFunc, error) {
+	if fallback <= 0 || fallback > maximum {
+		return nil, fmt.Errorf("invalid default limit %d: expected 1 to %d", fallback, maximum)
+	}
 
-// Reset the internal byte slice.
-func (w *SQL) Reset() {
-	w.data = w.data[:0]
-}
+	value := reflect.ValueOf(requested)
 
-// Write implements the io.Writer interface.
-func (w *SQL) Write(data []byte) (int, error) {
-	for _, b := range data {
-		switch b {
-		case ' ', '\n', '\r', '\t':
-			if len(w.data) > 0 && w.data[len(w.data)-1] != ' ' {
-				w.data = append(w.data, ' ')
-			}
-		default:
-			w.data = append(w.data, b)
-		}
+	for value.Kind() == reflect.Pointer && !value.IsNil() {
+		value = value.Elem()
 	}
 
-	return len(data), nil
-}
+	var limit int64
 
-// String implements the fmt.Stringer interface.
-func (w *SQL) String() string {
-	if len(w.data) == 0 {
-		return ""
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		limit = value.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		limit = int64(min(value.Uint(), uint64(maximum)))
+	case reflect.Invalid, reflect.Pointer:
+	default:
+		return nil, fmt.Errorf("invalid type %T: expected integer", requested)
 	}
 
-	if w.data[len(w.data)-1] == ' ' {
-		w.data = w.data[:len(w.data)-1]
+	switch {
+	case limit <= 0:
+		limit = fallback
+	case limit > maximum:
+		limit = maximum
 	}
 
-	return string(w.data)
+	return func(runner *Runner) (Raw, error) {
+		return runner.Bind(limit), nil
+	}, nil
 }
 
-var ident = "___sqlt___"
+// outArg appends arg without writing a placeholder, e.g. an OUT parameter of a stored procedure like
+// sql.Named("total", sql.Out{Dest: &total}). The call must reference the arg by itself, e.g.
+// 'EXEC count_books @author = {{ .Author }}, @total = @total OUTPUT {{ OutArg .Total }}' for 'SQLServer'.
+// Positional placeholders after the call are numbered after the arg.
+func outArg(arg any) RunnerFunc {
+	return func(runner *Runner) (Raw, error) {
+		runner.Args = append(runner.Args, arg)
 
-// copied from here: https://github.com/mhilton/sqltemplate/blob/main/escape.go
-func escape(text *template.Template) {
-	for _, tpl := range text.Templates() {
-		if tpl.Tree.Root != nil {
-			escapeNode(tpl.Tree, tpl.Tree.Root)
-		}
+		return "", nil
 	}
 }
 
-func escapeNode(s *parse.Tree, n parse.Node) {
-	switch v := n.(type) {
-	case *parse.ActionNode:
-		escapeNode(s, v.Pipe)
-	case *parse.IfNode:
-		escapeNode(s, v.List)
-		escapeNode(s, v.ElseList)
-	case *parse.ListNode:
-		if v == nil {
-			return
+// upsert emits the conflict clause of an insert, e.g. '{{ Upsert "id" "title" "author" }}'. conflict is a
+// comma-separated list of the conflicting columns and the columns in update are overwritten by the inserted values.
+// 'Postgres' and 'Sqlite' use 'ON CONFLICT ... DO UPDATE SET' with 'EXCLUDED.', 'MySQL' uses 'ON DUPLICATE KEY UPDATE'
+// with 'VALUES()'. Without update columns, conflicting rows are kept. 'SQLServer' is not supported.
+func upsert(conflict string, update ...string) (RunnerFunc, error) {
+	columns := strings.Split(conflict, ",")
+
+	for i, column := range columns {
+		columns[i] = strings.TrimSpace(column)
+
+		if !goodName(columns[i]) {
+			return nil, fmt.Errorf("invalid column name '%s'", columns[i])
 		}
+	}
 
-		for _, n := range v.Nodes {
-			escapeNode(s, n)
+	for _, column := range update {
+		if !goodName(column) {
+			return nil, fmt.Errorf("invalid column name '%s'", column)
 		}
-	case *parse.PipeNode:
-		if len(v.Decl) > 0 {
-			return
+	}
+
+	return func(runner *Runner) (Raw, error) {
+		sets := make([]string, len(update))
+
+		switch runner.config.Dialect {
+		case "MySQL":
+			if len(update) == 0 {
+				return Raw("ON DUPLICATE KEY UPDATE " + columns[0] + " = " + columns[0]), nil
+			}
+
+			for i, column := range update {
+				sets[i] = column + " = VALUES(" + column + ")"
+			}
+
+			return Raw("ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")), nil
+		case "SQLServer":
+			return "", errors.New("upsert is not supported by the dialect 'SQLServer'")
+		default:
+			target := "ON CONFLICT (" + strings.Join(columns, ", ") + ")"
+
+			if len(update) == 0 {
+				return Raw(target + " DO NOTHING"), nil
+			}
+
+			for i, column := range update {
+				sets[i] = column + " = EXCLUDED." + column
+			}
+
+			return Raw(target + " DO UPDATE SET " + strings.Join(sets, ", ")), nil
 		}
+	}, nil
+}
 
-		if len(v.Cmds) < 1 {
-			return
+// like binds term as a LIKE pattern, e.g. 'title LIKE {{ Like .Search "contains" }}'. The mode is 'contains',
+// 'prefix' or 'suffix'. The wildcards in term are escaped by a backslash and "ESCAPE '\'" is appended,
+// unless the backslash is the default escape character of the dialect ('Postgres' and 'MySQL').
+func like(term, mode string) (RunnerFunc, error) {
+	switch mode {
+	case "contains", "prefix", "suffix":
+	default:
+		return nil, fmt.Errorf("invalid like mode '%s'", mode)
+	}
+
+	return func(runner *Runner) (Raw, error) {
+		pattern := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(term)
+
+		if runner.config.Dialect == "SQLServer" {
+			pattern = strings.ReplaceAll(pattern, "[", `\[`)
 		}
 
-		cmd := v.Cmds[len(v.Cmds)-1]
-		if len(cmd.Args) == 1 && cmd.Args[0].Type() == parse.NodeIdentifier && cmd.Args[0].(*parse.IdentifierNode).Ident == ident {
-			return
+		switch mode {
+		case "contains":
+			pattern = "%" + pattern + "%"
+		case "prefix":
+			pattern += "%"
+		case "suffix":
+			pattern = "%" + pattern
 		}
 
-		v.Cmds = append(v.Cmds, &parse.CommandNode{
-			NodeType: parse.NodeCommand,
-			Args:     []parse.Node{parse.NewIdentifier(ident).SetTree(s).SetPos(cmd.Pos)},
-		})
-	case *parse.RangeNode:
-		escapeNode(s, v.List)
-		escapeNode(s, v.ElseList)
-	case *parse.WithNode:
-		escapeNode(s, v.List)
-		escapeNode(s, v.ElseList)
-	}
-}
+		placeholder := runner.Bind(pattern)
 
-// copied from the text/template package.
-func goodName(name string) bool {
-	if name == "" {
-		return false
-	}
-	for i, r := range name {
-		switch {
-		case r == '_':
-		case i == 0 && !unicode.IsLetter(r):
-			return false
-		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
-			return false
+		switch runner.config.Dialect {
+		case "Postgres", "MySQL":
+			return placeholder, nil
+		default:
+			return placeholder + ` ESCAPE '\'`, nil
 		}
+	}, nil
+}
+
+// quoteIdent quotes name according to the dialect and escapes embedded quote characters by doubling them.
+func quoteIdent(dialect Dialect, name string) Raw {
+	switch dialect {
+	case "MySQL":
+		return Raw("`" + strings.ReplaceAll(name, "`", "``") + "`")
+	case "SQLServer":
+		return Raw("[" + strings.ReplaceAll(name, "]", "]]") + "]")
+	default:
+		return Raw(`"` + strings.ReplaceAll(name, `"`, `""`) + `"`)
 	}
-	return true
 }
@@ -7,9 +7,15 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"log/slog"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
 	"text/template"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/spf13/afero"
@@ -1094,7 +1100,9 @@ func TestMapError(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	mock.ExpectQuery("id").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectQuery("b.id").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+
+	_, file, line, _ := runtime.Caller(0)
 
 	stmt := sqlt.QueryStmt[string, int64](
 		sqlt.Funcs(template.FuncMap{
@@ -1108,11 +1116,11 @@ func TestMapError(t *testing.T) {
 				}
 			},
 		}),
-		sqlt.Parse(`{{ MyScanner Dest "id" }}`),
+		sqlt.Parse(`{{ MyScanner Dest "b.id" }}`),
 	)
 
 	_, err = stmt.First(context.Background(), db, "TEST")
-	if err == nil || err.Error() != "ERROR" {
+	if err == nil || err.Error() != fmt.Sprintf("location: [%s:%d]: column 'id': ERROR", file, line+2) {
 		t.Fatal(err)
 	}
 }
@@ -1169,17 +1177,17 @@ func TestStmtPanic(t *testing.T) {
 	)
 
 	_, err := stmt.Exec(context.Background(), nil, "TEST")
-	if err == nil || err.Error() != `template: :1:3: executing "" at <Test>: error calling Test: ERROR` {
+	if err == nil || err.Error() != "template: :1:3: executing \"\" at <Test>: error calling Test: ERROR\n>    1 | {{ Test }}" {
 		t.Fatal(err)
 	}
 
 	_, err = stmt.Query(context.Background(), nil, "TEST")
-	if err == nil || err.Error() != `template: :1:3: executing "" at <Test>: error calling Test: ERROR` {
+	if err == nil || err.Error() != "template: :1:3: executing \"\" at <Test>: error calling Test: ERROR\n>    1 | {{ Test }}" {
 		t.Fatal(err)
 	}
 
 	_, err = stmt.QueryRow(context.Background(), nil, "TEST")
-	if err == nil || err.Error() != `template: :1:3: executing "" at <Test>: error calling Test: ERROR` {
+	if err == nil || err.Error() != "template: :1:3: executing \"\" at <Test>: error calling Test: ERROR\n>    1 | {{ Test }}" {
 		t.Fatal(err)
 	}
 }
@@ -1248,3 +1256,4097 @@ func TestStmtExecMissingParams(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestScanFlag(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT id, author_id IS NOT NULL, COALESCE(author_name, '') FROM books").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "has_author", "author_name"}).
+			AddRow(1, false, "").
+			AddRow(2, true, "Tolkien"),
+	)
+
+	type Author struct {
+		Name string
+	}
+
+	type Book struct {
+		ID     int64
+		Author *Author
+	}
+
+	stmt := sqlt.QueryStmt[any, Book](
+		sqlt.Funcs(template.FuncMap{
+			"ScanAuthor": sqlt.ScanFlag[Author],
+		}),
+		sqlt.Parse(`
+			SELECT
+				{{ ScanInt64 Dest.ID "id" }}
+				{{- ScanAuthor Dest.Author ", author_id IS NOT NULL" }}
+				{{- ScanString Dest.Author.Name ", COALESCE(author_name, '')" }}
+			FROM books
+		`),
+	)
+
+	books, err := stmt.All(context.Background(), db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(books) != 2 || books[0].Author != nil || books[1].Author == nil || books[1].Author.Name != "Tolkien" {
+		t.Fatal(books)
+	}
+}
+
+func TestSlowRender(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectExec("DELETE FROM books").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	var warned string
+
+	stmt := sqlt.Stmt[any](
+		sqlt.SlowRender{
+			Threshold: time.Millisecond,
+			Warn: func(runner *sqlt.Runner) {
+				warned = fmt.Sprintf("%s %t", runner.SQL, runner.RenderDuration > time.Millisecond)
+			},
+		},
+		sqlt.Funcs(template.FuncMap{
+			"Slow": func() sqlt.Raw {
+				time.Sleep(5 * time.Millisecond)
+
+				return "books"
+			},
+		}),
+		sqlt.Parse(`DELETE FROM {{ Slow }}`),
+	)
+
+	if _, err = stmt.Exec(context.Background(), db, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if warned != "DELETE FROM books true" {
+		t.Fatal(warned)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	for _, c := range []struct {
+		option sqlt.Option
+		sql    string
+	}{
+		{sqlt.Postgres(), "TRUNCATE TABLE books"},
+		{sqlt.Sqlite(), "DELETE FROM books"},
+		{sqlt.Dialect("MySQL"), "TRUNCATE TABLE books"},
+		{sqlt.Config{Dialect: "Postgres", Truncate: sqlt.TruncateDelete}, "DELETE FROM books"},
+		{sqlt.Config{Dialect: "Sqlite", Truncate: sqlt.TruncateTable}, "TRUNCATE TABLE books"},
+	} {
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mock.ExpectExec(c.sql).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		stmt := sqlt.Stmt[any](
+			c.option,
+			sqlt.Parse(`{{ Truncate "books" }}`),
+		)
+
+		if _, err = stmt.Exec(context.Background(), db, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stmt := sqlt.Stmt[string](
+		sqlt.Parse(`{{ Truncate . }}`),
+	)
+
+	if _, err := stmt.Exec(context.Background(), nil, "books; DROP TABLE users"); err == nil {
+		t.Fatal("expected invalid table name")
+	}
+}
+
+func TestScanJSONStrict(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT data FROM books").WillReturnRows(
+		sqlmock.NewRows([]string{"data"}).AddRow([]byte(`{"name": "TEST"}`)),
+	)
+
+	mock.ExpectQuery("SELECT data FROM books").WillReturnRows(
+		sqlmock.NewRows([]string{"data"}).AddRow([]byte(`{"name": "TEST", "extra": 1}`)),
+	)
+
+	type Data struct {
+		Name string `json:"name"`
+	}
+
+	type Book struct {
+		Data Data
+	}
+
+	stmt := sqlt.QueryStmt[any, Book](
+		sqlt.Funcs(template.FuncMap{
+			"ScanData": sqlt.ScanJSONStrict[Data],
+		}),
+		sqlt.Parse(`SELECT {{ ScanData Dest.Data "data" }} FROM books`),
+	)
+
+	book, err := stmt.First(context.Background(), db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if book.Data.Name != "TEST" {
+		t.Fatal(book)
+	}
+
+	_, err = stmt.First(context.Background(), db, nil)
+	if err == nil || !strings.Contains(err.Error(), `unknown field "extra"`) {
+		t.Fatal(err)
+	}
+}
+
+func TestSplitTime(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectExec("INSERT INTO events (id, date, time) VALUES ($1, $2, $3)").
+		WithArgs(1, "2024-12-24", "18:30:05").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	type Event struct {
+		ID   int64
+		Time time.Time
+	}
+
+	stmt := sqlt.Stmt[Event](
+		sqlt.Postgres(),
+		sqlt.Parse(`INSERT INTO events (id, date, time) VALUES ({{ .ID }}, {{ SplitTime .Time }})`),
+	)
+
+	_, err = stmt.Exec(context.Background(), db, Event{ID: 1, Time: time.Date(2024, 12, 24, 18, 30, 5, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegistryRenderAll(t *testing.T) {
+	registry := &sqlt.Registry{}
+
+	type Param struct {
+		Title string
+	}
+
+	_ = sqlt.Stmt[Param](
+		registry,
+		sqlt.Parse(`SELECT id FROM books WHERE title = {{ .Title }}`),
+	)
+
+	_ = sqlt.QueryStmt[Param, int64](
+		registry,
+		sqlt.Funcs(template.FuncMap{
+			"NotEmpty": func(str string) (string, error) {
+				if str == "" {
+					return "", errors.New("empty")
+				}
+
+				return str, nil
+			},
+		}),
+		sqlt.Parse(`SELECT id FROM books WHERE title = {{ NotEmpty .Title }}`),
+	)
+
+	_ = sqlt.Stmt[Param](
+		registry,
+		sqlt.Funcs(template.FuncMap{
+			"Panic": func() sqlt.Raw {
+				panic("PANIC")
+			},
+		}),
+		sqlt.Parse(`{{ Panic }}`),
+	)
+
+	errs := registry.RenderAll(context.Background())
+	if len(errs) != 2 {
+		t.Fatal(errs)
+	}
+
+	for location, err := range errs {
+		if !strings.Contains(location, "sqlt_test.go") || err == nil {
+			t.Fatal(location, err)
+		}
+	}
+}
+
+func TestRegistryDuplicateLocation(t *testing.T) {
+	registry := &sqlt.Registry{}
+
+	for _, text := range []string{`SELECT {{ Fail }}`, `SELECT 1`, `SELECT {{ Fail }}`} {
+		_ = sqlt.Stmt[any](
+			registry,
+			sqlt.Funcs(template.FuncMap{
+				"Fail": func() (string, error) {
+					return "", errors.New("fail")
+				},
+			}),
+			sqlt.Parse(text),
+		)
+	}
+
+	errs := registry.RenderAll(context.Background())
+	if len(errs) != 2 {
+		t.Fatal(errs)
+	}
+
+	var locations []string
+
+	for location := range errs {
+		locations = append(locations, location)
+	}
+
+	slices.Sort(locations)
+
+	if strings.Contains(locations[0], "#") || !strings.HasSuffix(locations[1], locations[0]+"#3") {
+		t.Fatal(locations)
+	}
+}
+
+func TestScanParseDurationP(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT timeout FROM jobs").WillReturnRows(
+		sqlmock.NewRows([]string{"timeout"}).
+			AddRow(nil).
+			AddRow("1h30m"),
+	)
+
+	type Job struct {
+		Timeout *time.Duration
+	}
+
+	stmt := sqlt.QueryStmt[any, Job](
+		sqlt.Parse(`SELECT {{ ScanParseDurationP Dest.Timeout "timeout" }} FROM jobs`),
+	)
+
+	jobs, err := stmt.All(context.Background(), db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jobs) != 2 || jobs[0].Timeout != nil || jobs[1].Timeout == nil || *jobs[1].Timeout != 90*time.Minute {
+		t.Fatal(jobs)
+	}
+}
+
+func TestLimitOffset(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT id FROM books ORDER BY id OFFSET @p1 ROWS FETCH NEXT @p2 ROWS ONLY").
+		WithArgs(20, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	mock.ExpectQuery("SELECT id FROM books ORDER BY id LIMIT $1 OFFSET $2").
+		WithArgs(10, 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	type Param struct {
+		Limit  int
+		Offset int
+	}
+
+	for _, config := range []sqlt.Config{sqlt.SQLServer(), sqlt.Postgres()} {
+		stmt := sqlt.QueryStmt[Param, int64](
+			config,
+			sqlt.Parse(`SELECT id FROM books ORDER BY id {{ LimitOffset .Limit .Offset }}`),
+		)
+
+		if _, err = stmt.All(context.Background(), db, Param{Limit: 10, Offset: 20}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stmt := sqlt.QueryStmt[Param, int64](
+		sqlt.SQLServer(),
+		sqlt.Parse(`SELECT id FROM books {{ LimitOffset .Limit .Offset }}`),
+	)
+
+	if _, err = stmt.All(context.Background(), db, Param{Limit: 10}); err == nil || !strings.Contains(err.Error(), "ORDER BY") {
+		t.Fatal(err)
+	}
+}
+
+func TestScanMixedColumnsAndJSON(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT id, title, meta, author FROM books").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "title", "meta", "author"}).
+			AddRow(1, "TEST", []byte(`{"pages": 300, "tags": ["a", "b"]}`), "Tolkien"),
+	)
+
+	type Meta struct {
+		Pages int      `json:"pages"`
+		Tags  []string `json:"tags"`
+	}
+
+	type Book struct {
+		ID     int64
+		Title  string
+		Meta   Meta
+		Author string
+	}
+
+	stmt := sqlt.QueryStmt[any, Book](
+		sqlt.Funcs(template.FuncMap{
+			"ScanMeta": sqlt.ScanJSON[Meta],
+		}),
+		sqlt.Parse(`
+			SELECT
+				{{ ScanInt64 Dest.ID "id" }}
+				{{- ScanString Dest.Title ", title" }}
+				{{- ScanMeta Dest.Meta ", meta" }}
+				{{- ScanString Dest.Author ", author" }}
+			FROM books
+		`),
+	)
+
+	book, err := stmt.One(context.Background(), db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if book.ID != 1 || book.Title != "TEST" || book.Author != "Tolkien" || book.Meta.Pages != 300 || len(book.Meta.Tags) != 2 {
+		t.Fatal(book)
+	}
+}
+
+func TestIter(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT id, title FROM books").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "title"}).
+			AddRow(1, "TEST").
+			AddRow(2, "TEST 2").
+			AddRow(3, "TEST 3"),
+	).RowsWillBeClosed()
+
+	mock.ExpectQuery("SELECT id, title FROM books").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "title"}).
+			AddRow(1, "TEST").
+			AddRow(2, "TEST 2"),
+	).RowsWillBeClosed()
+
+	type Book struct {
+		ID    int64
+		Title string
+	}
+
+	var ends int
+
+	stmt := sqlt.QueryStmt[any, Book](
+		sqlt.End(func(err error, runner *sqlt.Runner) {
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ends++
+		}),
+		sqlt.Parse(`
+			SELECT
+				{{ ScanInt64 Dest.ID "id" }}
+				{{- ScanString Dest.Title ", title" }}
+			FROM books
+		`),
+	)
+
+	var ids []int64
+
+	for book, err := range stmt.Iter(context.Background(), db, nil) {
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, book.ID)
+	}
+
+	if len(ids) != 3 || ids[2] != 3 || ends != 1 {
+		t.Fatal(ids, ends)
+	}
+
+	for book, err := range stmt.Iter(context.Background(), db, nil) {
+		if err != nil || book.ID != 1 {
+			t.Fatal(book, err)
+		}
+
+		break
+	}
+
+	if ends != 2 {
+		t.Fatal(ends)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIterError(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT id FROM books").WillReturnError(errors.New("ERROR"))
+
+	stmt := sqlt.QueryStmt[any, int64](
+		sqlt.Parse(`SELECT id FROM books`),
+	)
+
+	for _, err := range stmt.Iter(context.Background(), db, nil) {
+		if err == nil || err.Error() != "ERROR" {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestIdent(t *testing.T) {
+	for _, c := range []struct {
+		config sqlt.Config
+		sql    string
+	}{
+		{sqlt.Postgres(), `SELECT "my""col" FROM "books"`},
+		{sqlt.Sqlite(), `SELECT "my""col" FROM "books"`},
+		{sqlt.MySQL(), "SELECT `my\"col` FROM `books`"},
+		{sqlt.SQLServer(), `SELECT [my"col] FROM [books]`},
+	} {
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mock.ExpectExec(c.sql).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		stmt := sqlt.Stmt[string](
+			c.config,
+			sqlt.Parse(`SELECT {{ Ident . }} FROM {{ Ident "books" }}`),
+		)
+
+		if _, err = stmt.Exec(context.Background(), db, `my"col`); err != nil {
+			t.Fatal(c.config.Dialect, err)
+		}
+	}
+
+	stmt := sqlt.Stmt[string](
+		sqlt.MySQL(),
+		sqlt.Parse("SELECT {{ Ident . }}"),
+	)
+
+	if _, err := stmt.Exec(context.Background(), nil, "a\x00b"); err == nil {
+		t.Fatal("expected invalid identifier")
+	}
+}
+
+func TestIn(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT id FROM books WHERE title = $1 AND id IN ($2, $3, $4)").
+		WithArgs("TEST", 1, 2, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	mock.ExpectQuery("SELECT id FROM books WHERE title = $1 AND id IN (NULL)").
+		WithArgs("TEST").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	type Param struct {
+		Title string
+		IDs   []int64
+	}
+
+	stmt := sqlt.QueryStmt[Param, int64](
+		sqlt.Postgres(),
+		sqlt.Parse(`SELECT id FROM books WHERE title = {{ .Title }} AND id IN ({{ In .IDs }})`),
+	)
+
+	if _, err = stmt.All(context.Background(), db, Param{Title: "TEST", IDs: []int64{1, 2, 3}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = stmt.All(context.Background(), db, Param{Title: "TEST"}); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := sqlt.Stmt[int](
+		sqlt.Parse(`SELECT id FROM books WHERE id IN ({{ In . }})`),
+	)
+
+	if _, err = invalid.Exec(context.Background(), db, 1); err == nil {
+		t.Fatal("expected invalid type")
+	}
+}
+
+func TestNamedPlaceholder(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT id, title FROM books WHERE title = :arg1 AND id IN (:arg2, :arg3)").
+		WithArgs(sql.Named("arg1", "TEST"), sql.Named("arg2", 1), sql.Named("arg3", 2)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(1, "TEST"))
+
+	type Param struct {
+		Title string
+		IDs   []int64
+	}
+
+	type Book struct {
+		ID    int64
+		Title string
+	}
+
+	var args []any
+
+	stmt := sqlt.QueryStmt[Param, Book](
+		sqlt.Question(),
+		sqlt.NamedPlaceholder(":"),
+		sqlt.End(func(err error, runner *sqlt.Runner) {
+			args = append(args, runner.Args...)
+		}),
+		sqlt.Parse(`
+			SELECT
+				{{ ScanInt64 Dest.ID "id" }}
+				{{- ScanString Dest.Title ", title" }}
+			FROM books WHERE title = {{ .Title }} AND id IN ({{ In .IDs }})
+		`),
+	)
+
+	book, err := stmt.First(context.Background(), db, Param{Title: "TEST", IDs: []int64{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if book.ID != 1 || len(args) != 3 || args[0] != any(sql.Named("arg1", "TEST")) {
+		t.Fatal(book, args)
+	}
+}
+
+func TestRender(t *testing.T) {
+	type Param struct {
+		Title string
+		IDs   []int64
+	}
+
+	stmt := sqlt.Stmt[Param](
+		sqlt.Postgres(),
+		sqlt.Parse(`DELETE FROM books WHERE title = {{ .Title }} OR id IN ({{ In .IDs }})`),
+	)
+
+	expr, err := stmt.Render(context.Background(), Param{Title: "TEST", IDs: []int64{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expr.SQL != "DELETE FROM books WHERE title = $1 OR id IN ($2, $3)" || fmt.Sprint(expr.Args) != "[TEST 1 2]" {
+		t.Fatal(expr)
+	}
+
+	type Book struct {
+		ID int64
+	}
+
+	query := sqlt.QueryStmt[Param, Book](
+		sqlt.Parse(`SELECT {{ ScanInt64 Dest.ID "id" }} FROM books WHERE title = {{ .Title }}`),
+	)
+
+	expr, err = query.Render(context.Background(), Param{Title: "TEST"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expr.SQL != "SELECT id FROM books WHERE title = ?" || fmt.Sprint(expr.Args) != "[TEST]" {
+		t.Fatal(expr)
+	}
+}
+
+func TestExecReturning(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("INSERT INTO books (title) VALUES ($1), ($2) RETURNING id, title").
+		WithArgs("TEST", "TEST 2").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(1, "TEST").AddRow(2, "TEST 2"))
+
+	type Book struct {
+		ID    int64
+		Title string
+	}
+
+	stmt := sqlt.QueryStmt[[]string, Book](
+		sqlt.Postgres(),
+		sqlt.Parse(`
+			INSERT INTO books (title) VALUES
+			{{ range $i, $t := . }}{{ if $i }}, {{ end }}({{ $t }}){{ end }}
+			RETURNING {{ ScanInt64 Dest.ID "id" }}{{ ScanString Dest.Title ", title" }}
+		`),
+	)
+
+	books, affected, err := stmt.ExecReturning(context.Background(), db, []string{"TEST", "TEST 2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if affected != 2 || books[1].ID != 2 || books[1].Title != "TEST 2" {
+		t.Fatal(books, affected)
+	}
+}
+
+func TestValues(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectExec("INSERT INTO books (id, title) VALUES ($1, $2), ($3, $4)").
+		WithArgs(1, "TEST", 2, "TEST 2").
+		WillReturnResult(sqlmock.NewResult(2, 2))
+
+	type Book struct {
+		ID    int64
+		Title string
+	}
+
+	stmt := sqlt.Stmt[[]Book](
+		sqlt.Postgres(),
+		sqlt.Parse(`INSERT INTO books (id, title) VALUES {{ Values . "ID" "Title" }}`),
+	)
+
+	if _, err = stmt.Exec(context.Background(), db, []Book{{ID: 1, Title: "TEST"}, {ID: 2, Title: "TEST 2"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := sqlt.Stmt[[]Book](
+		sqlt.Parse(`INSERT INTO books (id, title) VALUES {{ Values . "ID" "Titel" }}`),
+	)
+
+	if _, err = invalid.Exec(context.Background(), db, []Book{{ID: 1}}); err == nil || !strings.Contains(err.Error(), "field 'Titel' not found") {
+		t.Fatal(err)
+	}
+
+	pointers := sqlt.Stmt[[]*Book](
+		sqlt.Parse(`INSERT INTO books (id, title) VALUES {{ Values . "ID" "Title" }}`),
+	)
+
+	if _, err = pointers.Exec(context.Background(), db, []*Book{{ID: 1}, nil}); err == nil || !strings.Contains(err.Error(), "element 1 is nil") {
+		t.Fatal(err)
+	}
+
+	type Secret struct {
+		ID    int64
+		title string
+	}
+
+	unexported := sqlt.Stmt[[]Secret](
+		sqlt.Parse(`INSERT INTO books (id, title) VALUES {{ Values . "ID" "title" }}`),
+	)
+
+	if _, err = unexported.Exec(context.Background(), db, []Secret{{ID: 1, title: "TEST"}}); err == nil || !strings.Contains(err.Error(), "field 'title' is not exported") {
+		t.Fatal(err)
+	}
+}
+
+func TestRunnerParam(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectExec("DELETE FROM books WHERE id = ?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	var param any
+
+	stmt := sqlt.Stmt[int64](
+		sqlt.End(func(err error, runner *sqlt.Runner) {
+			param = runner.Param
+		}),
+		sqlt.Parse(`DELETE FROM books WHERE id = {{ . }}`),
+	)
+
+	if _, err = stmt.Exec(context.Background(), db, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if param != any(int64(1)) {
+		t.Fatal(param)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errBusy := errors.New("SQLITE_BUSY")
+
+	mock.ExpectExec("UPDATE books SET title = ?").WithArgs("TEST").WillReturnError(errBusy)
+	mock.ExpectExec("UPDATE books SET title = ?").WithArgs("TEST").WillReturnError(errBusy)
+	mock.ExpectExec("UPDATE books SET title = ?").WithArgs("TEST").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT id FROM books").WillReturnError(errors.New("ERROR"))
+
+	var attempt int
+
+	retry := sqlt.Retry(3, func(attempt int) time.Duration {
+		return time.Duration(attempt) * time.Millisecond
+	}, func(err error) bool {
+		return errors.Is(err, errBusy)
+	})
+
+	stmt := sqlt.Stmt[string](
+		retry,
+		sqlt.End(func(err error, runner *sqlt.Runner) {
+			attempt = runner.Attempt
+		}),
+		sqlt.Parse(`UPDATE books SET title = {{ . }}`),
+	)
+
+	if _, err = stmt.Exec(context.Background(), db, "TEST"); err != nil {
+		t.Fatal(err)
+	}
+
+	if attempt != 3 {
+		t.Fatal(attempt)
+	}
+
+	query := sqlt.QueryStmt[any, int64](
+		retry,
+		sqlt.End(func(err error, runner *sqlt.Runner) {
+			attempt = runner.Attempt
+		}),
+		sqlt.Parse(`SELECT id FROM books`),
+	)
+
+	if _, err = query.All(context.Background(), db, nil); err == nil || attempt != 1 {
+		t.Fatal(err, attempt)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	cancelled := sqlt.Stmt[string](
+		sqlt.Retry(3, func(attempt int) time.Duration {
+			cancel()
+
+			return time.Hour
+		}, func(err error) bool {
+			return true
+		}),
+		sqlt.Parse(`UPDATE books SET title = {{ . }}`),
+	)
+
+	mock.ExpectExec("UPDATE books SET title = ?").WithArgs("TEST").WillReturnError(errBusy)
+
+	if _, err = cancelled.Exec(ctx, db, "TEST"); !errors.Is(err, context.Canceled) || !errors.Is(err, errBusy) {
+		t.Fatal(err)
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prepared := mock.ExpectPrepare("SELECT id FROM books WHERE title = ?").WillBeClosed()
+	prepared.ExpectQuery().WithArgs("TEST").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	prepared.ExpectQuery().WithArgs("TEST 2").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+
+	other := mock.ExpectPrepare("SELECT id FROM books WHERE title = ? LIMIT 1")
+	other.ExpectQuery().WithArgs("TEST").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM books WHERE title = ?").WithArgs("TEST").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	type Param struct {
+		Title string
+		Limit bool
+	}
+
+	stmt := sqlt.QueryStmt[Param, int64](
+		sqlt.Prepare(1),
+		sqlt.Parse(`SELECT id FROM books WHERE title = {{ .Title }}{{ if .Limit }} LIMIT 1{{ end }}`),
+	)
+
+	for _, param := range []Param{{Title: "TEST"}, {Title: "TEST 2"}, {Title: "TEST", Limit: true}} {
+		if _, err = stmt.One(context.Background(), db, param); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if stats := stmt.Stats(); stats != (sqlt.Stats{Hits: 1, Misses: 2, Evictions: 1, Len: 1}) {
+		t.Fatal(stats)
+	}
+
+	err = sqlt.InTx(context.Background(), nil, db, func(db sqlt.DB) error {
+		_, err := stmt.One(context.Background(), db, Param{Title: "TEST"})
+
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyset(t *testing.T) {
+	type Cursor struct {
+		CreatedAt string
+		ID        int64
+	}
+
+	for _, c := range []struct {
+		config  sqlt.Config
+		columns string
+		sql     string
+		args    string
+	}{
+		{sqlt.Postgres(), `"created_at" "id"`, "SELECT id FROM books WHERE (created_at, id) > ($1, $2)", "[2024 7]"},
+		{sqlt.Sqlite(), `"created_at DESC" "id DESC"`, "SELECT id FROM books WHERE (created_at, id) < (?, ?)", "[2024 7]"},
+		{sqlt.Postgres(), `"created_at DESC" "id"`, "SELECT id FROM books WHERE ((created_at < $1) OR (created_at = $2 AND id > $3))", "[2024 2024 7]"},
+		{sqlt.SQLServer(), `"created_at" "id"`, "SELECT id FROM books WHERE ((created_at > @p1) OR (created_at = @p2 AND id > @p3))", "[2024 2024 7]"},
+	} {
+		stmt := sqlt.Stmt[Cursor](
+			c.config,
+			sqlt.Parse(`SELECT id FROM books WHERE {{ Keyset "ASC" . `+c.columns+` }}`),
+		)
+
+		expr, err := stmt.Render(context.Background(), Cursor{CreatedAt: "2024", ID: 7})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expr.SQL != c.sql || fmt.Sprint(expr.Args) != c.args {
+			t.Fatal(expr)
+		}
+	}
+
+	stmt := sqlt.Stmt[Cursor](
+		sqlt.Parse(`SELECT id FROM books WHERE {{ Keyset "ASC" . "id" }}`),
+	)
+
+	if _, err := stmt.Render(context.Background(), Cursor{}); err == nil {
+		t.Fatal("expected mismatching cursor")
+	}
+}
+
+func TestScanAll(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT b.id, b.title AS book_title, Pages FROM books b").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "book_title", "pages"}).AddRow(1, "TEST", 300),
+	)
+
+	type Book struct {
+		ID    int64  `sql:"id"`
+		Title string `sql:"book_title"`
+		Pages int64
+	}
+
+	stmt := sqlt.QueryStmt[any, Book](
+		sqlt.Parse(`SELECT {{ ScanAll Dest "b.id, b.title AS book_title, Pages" }} FROM books b`),
+	)
+
+	book, err := stmt.One(context.Background(), db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if book.ID != 1 || book.Title != "TEST" || book.Pages != 300 {
+		t.Fatal(book)
+	}
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "template: :1:10: column 'author' has no matching field") {
+			t.Fatal(r)
+		}
+	}()
+
+	_ = sqlt.QueryStmt[any, Book](
+		sqlt.Parse(`SELECT {{ ScanAll Dest "id, author" }} FROM books`),
+	)
+}
+
+func TestScanAllExpressions(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT id, COALESCE(subtitle, title, ',') AS book_title, CONCAT('(', pages) AS Pages FROM books").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "book_title", "pages"}).AddRow(1, "TEST", "(300"),
+	)
+
+	type Book struct {
+		ID    int64  `sql:"id"`
+		Title string `sql:"book_title"`
+		Pages string
+	}
+
+	stmt := sqlt.QueryStmt[any, Book](
+		sqlt.Parse(`SELECT {{ ScanAll Dest "id, COALESCE(subtitle, title, ',') AS book_title, CONCAT('(', pages) AS Pages" }} FROM books`),
+	)
+
+	book, err := stmt.One(context.Background(), db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if book.ID != 1 || book.Title != "TEST" || book.Pages != "(300" {
+		t.Fatal(book)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectExec("DELETE FROM books WHERE id = ?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM books WHERE id = ?").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	var validated []string
+
+	stmt := sqlt.Stmt[int64](
+		sqlt.Validate(func(ctx context.Context, sql string) error {
+			validated = append(validated, sql)
+
+			if strings.Contains(sql, "DELET ") {
+				return errors.New("syntax error")
+			}
+
+			return nil
+		}),
+		sqlt.Funcs(template.FuncMap{
+			"Delete": func(id int64) sqlt.Raw {
+				if id > 2 {
+					return "DELET"
+				}
+
+				return "DELETE"
+			},
+		}),
+		sqlt.Parse(`{{ Delete . }} FROM books WHERE id = {{ . }}`),
+	)
+
+	for _, id := range []int64{1, 2} {
+		if _, err = stmt.Exec(context.Background(), db, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = stmt.Render(context.Background(), 3); err == nil || err.Error() != "syntax error" {
+		t.Fatal(err)
+	}
+
+	if len(validated) != 2 {
+		t.Fatal(validated)
+	}
+}
+
+func TestAllPage(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT COUNT(*) FROM books WHERE author = $1").WithArgs("Rowling").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery("SELECT title FROM books WHERE author = $1 ORDER BY title LIMIT $2 OFFSET $3").WithArgs("Rowling", int64(2), int64(0)).
+		WillReturnRows(sqlmock.NewRows([]string{"title"}).AddRow("A").AddRow("B"))
+
+	stmt := sqlt.QueryStmt[string, string](
+		sqlt.Dollar(),
+		sqlt.Parse(`
+			{{ define "where" }}FROM books WHERE author = {{ . }}{{ end }}
+			{{ define "count" }}SELECT COUNT(*) {{ template "where" . }}{{ end }}
+			SELECT title {{ template "where" . }} ORDER BY title
+		`),
+	)
+
+	page, err := stmt.AllPage(context.Background(), db, "Rowling", 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(page.Items) != 2 || page.Items[1] != "B" || page.Total != 3 || !page.HasMore {
+		t.Fatal(page)
+	}
+
+	noCount := sqlt.QueryStmt[string, string](
+		sqlt.Parse(`SELECT title FROM books`),
+	)
+
+	if _, err = noCount.AllPage(context.Background(), db, "", 2, 0); err == nil {
+		t.Fatal("expected missing count template error")
+	}
+}
+
+func TestScanFuncs(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT price FROM books").
+		WillReturnRows(sqlmock.NewRows([]string{"price"}).AddRow("12.34"))
+
+	type Book struct {
+		Cents int64
+	}
+
+	stmt := sqlt.QueryStmt[struct{}, Book](
+		sqlt.ScanFuncs(map[string]func(dest any, str string, args ...any) (sqlt.Scanner, error){
+			"Money": func(dest any, str string, args ...any) (sqlt.Scanner, error) {
+				cents, ok := dest.(*int64)
+				if !ok {
+					return sqlt.Scanner{}, errors.New("invalid destination")
+				}
+
+				var src string
+
+				return sqlt.Scanner{
+					SQL:   str,
+					Value: &src,
+					Map: func() error {
+						var euros, rest int64
+
+						if _, err := fmt.Sscanf(src, "%d.%d", &euros, &rest); err != nil {
+							return err
+						}
+
+						*cents = euros*100 + rest
+
+						return nil
+					},
+				}, nil
+			},
+		}),
+		sqlt.Parse(`SELECT {{ ScanMoney Dest.Cents "price" }} FROM books`),
+	)
+
+	book, err := stmt.One(context.Background(), db, struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if book.Cents != 1234 {
+		t.Fatal(book)
+	}
+}
+
+func TestExecTx(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM books WHERE id = ?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM books WHERE id = ?").WithArgs(2).WillReturnError(errors.New("locked"))
+	mock.ExpectRollback()
+
+	stmt := sqlt.Stmt[int64](
+		sqlt.Parse(`DELETE FROM books WHERE id = {{ . }}`),
+	)
+
+	result, err := stmt.ExecTx(context.Background(), db, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if affected, _ := result.RowsAffected(); affected != 1 {
+		t.Fatal(affected)
+	}
+
+	if _, err = stmt.ExecTx(context.Background(), db, nil, 2); err == nil || err.Error() != "locked" {
+		t.Fatal(err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectExec("INSERT INTO tags (book_id, tag) VALUES ($1, $2) ON CONFLICT DO NOTHING; SELECT concat($3 || $4 || $5)").
+		WithArgs(1, "go", "a", "b", "c").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	type Param struct {
+		Row   []any
+		Parts []string
+	}
+
+	stmt := sqlt.Stmt[Param](
+		sqlt.Postgres(),
+		sqlt.Parse(`INSERT INTO tags (book_id, tag) VALUES ({{ Join .Row ", " }}) ON CONFLICT DO NOTHING; SELECT concat({{ Join .Parts " || " }})`),
+	)
+
+	if _, err = stmt.Exec(context.Background(), db, Param{Row: []any{1, "go"}, Parts: []string{"a", "b", "c"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := sqlt.Stmt[int](
+		sqlt.Parse(`SELECT {{ Join . ", " }}`),
+	)
+
+	if _, err = invalid.Exec(context.Background(), db, 1); err == nil {
+		t.Fatal("expected invalid type")
+	}
+}
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (tt *testTracer) Start(ctx context.Context, name string) (context.Context, sqlt.Span) {
+	span := &testSpan{name: name, attributes: map[string]any{}}
+
+	tt.spans = append(tt.spans, span)
+
+	return ctx, span
+}
+
+type testSpan struct {
+	name       string
+	attributes map[string]any
+	err        error
+	ended      bool
+}
+
+func (ts *testSpan) SetAttribute(key string, value any) {
+	ts.attributes[key] = value
+}
+
+func (ts *testSpan) End(err error) {
+	ts.err = err
+	ts.ended = true
+}
+
+func TestTrace(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT title FROM books WHERE id = ?").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"title"}).AddRow("A"))
+	mock.ExpectQuery("SELECT title FROM books WHERE id = ?").WithArgs(2).
+		WillReturnError(errors.New("boom"))
+
+	tracer := &testTracer{}
+
+	stmt := sqlt.QueryStmt[int64, string](
+		sqlt.Trace(tracer),
+		sqlt.Parse(`SELECT title FROM books WHERE id = {{ . }}`),
+	)
+
+	if _, err = stmt.First(context.Background(), db, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = stmt.All(context.Background(), db, 2); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if len(tracer.spans) != 2 {
+		t.Fatal(tracer.spans)
+	}
+
+	span := tracer.spans[0]
+
+	if !span.ended || span.err != nil || !strings.Contains(span.name, "sqlt_test.go") ||
+		span.attributes["db.statement"] != "SELECT title FROM books WHERE id = ?" || span.attributes["db.args"] != 1 ||
+		span.attributes["sqlt.prepared"] != false {
+		t.Fatal(span)
+	}
+
+	if span = tracer.spans[1]; !span.ended || span.err == nil || span.err.Error() != "boom" {
+		t.Fatal(span)
+	}
+}
+
+func TestCount(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT COUNT(*) FROM books WHERE author = ?").WithArgs("Rowling").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
+
+	stmt := sqlt.Stmt[string](
+		sqlt.Parse(`SELECT COUNT(*) FROM books WHERE author = {{ . }}`),
+	)
+
+	count, err := stmt.Count(context.Background(), db, "Rowling")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 7 {
+		t.Fatal(count)
+	}
+}
+
+func TestScalar(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT MAX(price) FROM books WHERE author = ?").WithArgs("Rowling").
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(12.5))
+	mock.ExpectQuery("SELECT MAX(price) FROM books WHERE author = ?").WithArgs("Tolkien").
+		WillReturnRows(sqlmock.NewRows([]string{"max"}))
+
+	stmt := sqlt.Stmt[string](
+		sqlt.Parse(`SELECT MAX(price) FROM books WHERE author = {{ . }}`),
+	)
+
+	price, err := sqlt.Scalar[float64](context.Background(), stmt, db, "Rowling")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if price != 12.5 {
+		t.Fatal(price)
+	}
+
+	if _, err = sqlt.Scalar[float64](context.Background(), stmt, db, "Tolkien"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatal(err)
+	}
+}
+
+func TestExecWith(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectExec("DELETE FROM books WHERE id = $1").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM books WHERE id = ?").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM books WHERE id = $1").WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	stmt := sqlt.Stmt[int64](
+		sqlt.Postgres(),
+		sqlt.Parse(`DELETE FROM books WHERE id = {{ . }}`),
+	)
+
+	if _, err = stmt.ExecWith(context.Background(), db, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = stmt.ExecWith(context.Background(), db, 2, sqlt.Question()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = stmt.Exec(context.Background(), db, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = stmt.ExecWith(context.Background(), db, 4, sqlt.Parse(`SELECT 1`)); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type routeKey struct{}
+
+func TestComment(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectExec("DELETE FROM books WHERE id = ? /*app='svc',route='%2Fbooks%2A%2F%27%20--'*/").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM books WHERE id = ?").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	stmt := sqlt.Stmt[int64](
+		sqlt.Comment(func(ctx context.Context) map[string]string {
+			route, ok := ctx.Value(routeKey{}).(string)
+			if !ok {
+				return nil
+			}
+
+			return map[string]string{
+				"route": route,
+				"app":   "svc",
+			}
+		}),
+		sqlt.Parse(`DELETE FROM books WHERE id = {{ . }}`),
+	)
+
+	if _, err = stmt.Exec(context.WithValue(context.Background(), routeKey{}, "/books*/' --"), db, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = stmt.Exec(context.Background(), db, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTemplateError(t *testing.T) {
+	stmt := sqlt.Stmt[string](
+		sqlt.Funcs(template.FuncMap{
+			"Fail": func() (sqlt.Raw, error) {
+				return "", errors.New("failed")
+			},
+		}),
+		sqlt.Parse(`
+			SELECT id
+			FROM books
+			WHERE {{ Fail }}
+			ORDER BY id
+		`),
+	)
+
+	_, err := stmt.Render(context.Background(), "")
+
+	var templateErr sqlt.TemplateError
+
+	if !errors.As(err, &templateErr) {
+		t.Fatal(err)
+	}
+
+	if templateErr.Line != 4 || templateErr.Snippet != "     3 | \t\t\tFROM books\n>    4 | \t\t\tWHERE {{ Fail }}\n     5 | \t\t\tORDER BY id" {
+		t.Fatal(templateErr.Line, templateErr.Snippet)
+	}
+}
+
+func TestPreserveWhitespace(t *testing.T) {
+	stmt := sqlt.Stmt[string](
+		sqlt.PreserveWhitespace(true),
+		sqlt.Parse("SELECT id\n  FROM books\n  WHERE title = '{{ Raw . }}'"),
+	)
+
+	expr, err := stmt.Render(context.Background(), "a  b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expr.SQL != "SELECT id\n  FROM books\n  WHERE title = 'a  b'" {
+		t.Fatal(expr.SQL)
+	}
+}
+
+func TestQuotedLiterals(t *testing.T) {
+	stmt := sqlt.Stmt[string](
+		sqlt.Parse(`
+			SELECT id
+			FROM books
+			WHERE title = 'a  b'
+				AND note = 'it''s   {{ Raw . }}  ok'
+				AND tag  =  ''
+		`),
+	)
+
+	expr, err := stmt.Render(context.Background(), "  multi  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expr.SQL != "SELECT id FROM books WHERE title = 'a  b' AND note = 'it''s     multi    ok' AND tag = ''" {
+		t.Fatal(expr.SQL)
+	}
+}
+
+func TestAllMap(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT id, title, price FROM books WHERE author = ?").WithArgs("Rowling").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "price"}).AddRow(1, []byte("A"), nil).AddRow(2, "B", 9.5))
+
+	stmt := sqlt.Stmt[string](
+		sqlt.Parse(`SELECT id, title, price FROM books WHERE author = {{ . }}`),
+	)
+
+	rows, err := stmt.AllMap(context.Background(), db, "Rowling")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 2 || rows[0]["title"] != "A" || rows[0]["price"] != nil || rows[1]["id"] != int64(2) || rows[1]["price"] != 9.5 {
+		t.Fatal(rows)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type Book struct {
+		ID    int64 `sql:"id"`
+		Title string
+	}
+
+	mock.ExpectBegin()
+
+	prepare := mock.ExpectPrepare("COPY books (id, Title) FROM STDIN")
+	prepare.ExpectExec().WithArgs(1, "A").WillReturnResult(sqlmock.NewResult(0, 0))
+	prepare.ExpectExec().WithArgs(2, "B").WillReturnResult(sqlmock.NewResult(0, 0))
+	prepare.ExpectExec().WithoutArgs().WillReturnResult(sqlmock.NewResult(0, 2))
+
+	mock.ExpectCommit()
+
+	err = sqlt.InTx(context.Background(), nil, db, func(tx sqlt.DB) error {
+		count, err := sqlt.Copy(context.Background(), tx.(*sql.Tx), "books", []string{"id", "Title"}, []Book{{1, "A"}, {2, "B"}})
+		if err != nil {
+			return err
+		}
+
+		if count != 2 {
+			return fmt.Errorf("unexpected count %d", count)
+		}
+
+		_, err = sqlt.Copy(context.Background(), tx.(*sql.Tx), "books", []string{"author"}, []Book{{1, "A"}})
+		if err == nil {
+			return errors.New("expected missing field")
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type replicaKey struct{}
+
+func TestDialectFunc(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery(`SELECT "title" FROM books WHERE id = $1 -- Postgres`).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"title"}).AddRow("A"))
+	mock.ExpectQuery("SELECT `title` FROM books WHERE id = ? -- MySQL").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"title"}).AddRow("B"))
+	mock.ExpectQuery(`SELECT "title" FROM books WHERE id = $1 -- Postgres`).WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"title"}).AddRow("C"))
+
+	stmt := sqlt.QueryStmt[int64, string](
+		sqlt.Postgres(),
+		sqlt.DialectFunc(func(ctx context.Context) string {
+			if ctx.Value(replicaKey{}) != nil {
+				return "MySQL"
+			}
+
+			return ""
+		}),
+		sqlt.Parse(`SELECT {{ Ident "title" }} FROM books WHERE id = {{ . }} -- {{ Raw Dialect }}`),
+	)
+
+	if _, err = stmt.First(context.Background(), db, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = stmt.First(context.WithValue(context.Background(), replicaKey{}, true), db, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = stmt.First(context.Background(), db, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFormatSQL(t *testing.T) {
+	formatted := sqlt.FormatSQL("SELECT b.id, a.name FROM books b LEFT JOIN authors a ON a.id = b.author_id WHERE b.title = 'a  and b' AND b.id IN (SELECT id FROM tags WHERE x = 1 AND y = 2) OR b.id = ? ORDER BY b.id LIMIT ?")
+
+	expected := `SELECT b.id, a.name
+FROM books b
+LEFT JOIN authors a ON a.id = b.author_id
+WHERE b.title = 'a  and b'
+  AND b.id IN (SELECT id FROM tags WHERE x = 1 AND y = 2)
+  OR b.id = ?
+ORDER BY b.id
+LIMIT ?`
+
+	if formatted != expected {
+		t.Fatal(formatted)
+	}
+}
+
+func TestParseReader(t *testing.T) {
+	stmt := sqlt.Stmt[int64](
+		sqlt.ParseReader("delete_book", strings.NewReader(`DELETE FROM books WHERE id = {{ . }}`)),
+	)
+
+	expr, err := stmt.Render(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expr.SQL != "DELETE FROM books WHERE id = ?" {
+		t.Fatal(expr.SQL)
+	}
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "parse invalid:") {
+			t.Fatal(r)
+		}
+	}()
+
+	_ = sqlt.Stmt[int64](
+		sqlt.ParseReader("invalid", strings.NewReader(`{{ if }}`)),
+	)
+}
+
+func TestClose(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prepared := mock.ExpectPrepare("DELETE FROM books WHERE id = ?").WillBeClosed()
+	prepared.ExpectExec().WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	stmt := sqlt.Stmt[int64](
+		sqlt.Prepare(10),
+		sqlt.Parse(`DELETE FROM books WHERE id = {{ . }}`),
+	)
+
+	if _, err = stmt.Exec(context.Background(), db, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = stmt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := stmt.Stats(); stats.Len != 0 {
+		t.Fatal(stats)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = sqlt.QueryStmt[int64, string](sqlt.Parse(`SELECT 1`)).Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanParseTimeInLocation(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT created_at FROM books").
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow("2024-01-02 15:04:05"))
+
+	type Book struct {
+		CreatedAt time.Time
+	}
+
+	stmt := sqlt.QueryStmt[string, Book](
+		sqlt.Parse(`SELECT {{ ScanParseTimeInLocation Dest.CreatedAt "created_at" "2006-01-02 15:04:05" . }} FROM books`),
+	)
+
+	book, err := stmt.One(context.Background(), db, "America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !book.CreatedAt.Equal(time.Date(2024, 1, 2, 20, 4, 5, 0, time.UTC)) {
+		t.Fatal(book.CreatedAt)
+	}
+
+	if _, err = stmt.One(context.Background(), db, "Invalid/Location"); err == nil {
+		t.Fatal("expected invalid location")
+	}
+}
+
+func TestMaxSQLSize(t *testing.T) {
+	stmt := sqlt.Stmt[[]int](
+		sqlt.MaxSQLSize(40),
+		sqlt.Parse(`SELECT id FROM books WHERE id IN ({{ In . }})`),
+	)
+
+	if _, err := stmt.Render(context.Background(), []int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := stmt.Render(context.Background(), []int{1, 2, 3, 4, 5, 6, 7, 8})
+	if err == nil || !strings.Contains(err.Error(), "rendered sql exceeds 40 bytes") {
+		t.Fatal(err)
+	}
+}
+
+func TestRegisterDialect(t *testing.T) {
+	sqlt.RegisterDialect("ClickHouse", sqlt.Config{Placeholder: sqlt.Colon()})
+
+	for _, c := range []struct {
+		opts     []sqlt.Option
+		expected string
+	}{
+		{[]sqlt.Option{sqlt.Dialect("Postgres")}, `SELECT "id" FROM books WHERE id = $1`},
+		{[]sqlt.Option{sqlt.Dialect("Postgres"), sqlt.Question()}, `SELECT "id" FROM books WHERE id = ?`},
+		{[]sqlt.Option{sqlt.Dialect("MySQL")}, "SELECT `id` FROM books WHERE id = ?"},
+		{[]sqlt.Option{sqlt.Dialect("ClickHouse")}, `SELECT "id" FROM books WHERE id = :1`},
+		{[]sqlt.Option{sqlt.Config{Dialect: "Postgres"}}, `SELECT "id" FROM books WHERE id = $1`},
+		{[]sqlt.Option{sqlt.Question(), sqlt.Dialect("Postgres")}, `SELECT "id" FROM books WHERE id = ?`},
+		{[]sqlt.Option{sqlt.Question(), sqlt.Config{Dialect: "Postgres"}}, `SELECT "id" FROM books WHERE id = ?`},
+		{[]sqlt.Option{sqlt.Config{Dialect: "Postgres", Placeholder: sqlt.Question()}}, `SELECT "id" FROM books WHERE id = ?`},
+		{[]sqlt.Option{sqlt.Postgres(), sqlt.Dialect("MySQL")}, "SELECT `id` FROM books WHERE id = ?"},
+	} {
+		stmt := sqlt.Stmt[int64](
+			append(c.opts, sqlt.Parse(`SELECT {{ Ident "id" }} FROM books WHERE id = {{ . }}`))...,
+		)
+
+		expr, err := stmt.Render(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expr.SQL != c.expected {
+			t.Fatal(expr.SQL)
+		}
+	}
+}
+
+func TestScanNull(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT title, price FROM books").
+		WillReturnRows(sqlmock.NewRows([]string{"title", "price"}).AddRow("A", nil).AddRow(nil, 9.5))
+
+	type Book struct {
+		Title sql.Null[string]
+		Price sql.Null[float64]
+	}
+
+	stmt := sqlt.QueryStmt[struct{}, Book](
+		sqlt.Parse(`SELECT {{ ScanNull Dest.Title "title" }}, {{ ScanNull Dest.Price "price" }} FROM books`),
+	)
+
+	books, err := stmt.All(context.Background(), db, struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(books) != 2 || books[0].Title.V != "A" || books[0].Price.Valid || books[1].Title.Valid || books[1].Price.V != 9.5 {
+		t.Fatal(books)
+	}
+}
+
+func TestWhere(t *testing.T) {
+	type Param struct {
+		Title  string
+		Author string
+	}
+
+	stmt := sqlt.Stmt[Param](
+		sqlt.Postgres(),
+		sqlt.Parse(`
+			SELECT id FROM books
+			{{ $w := Where }}
+			{{ if .Title }}{{ $w.And "title =" .Title }}{{ end }}
+			{{ if .Author }}{{ $w.And "author =" .Author }}{{ end }}
+			{{ $w.And "deleted_at IS NULL" }}
+			{{ $w.SQL }}
+			LIMIT {{ 10 }}
+		`),
+	)
+
+	for _, c := range []struct {
+		param    Param
+		expected string
+		args     int
+	}{
+		{Param{}, "SELECT id FROM books WHERE deleted_at IS NULL LIMIT $1", 1},
+		{Param{Title: "A"}, "SELECT id FROM books WHERE title = $1 AND deleted_at IS NULL LIMIT $2", 2},
+		{Param{Title: "A", Author: "B"}, "SELECT id FROM books WHERE title = $1 AND author = $2 AND deleted_at IS NULL LIMIT $3", 3},
+	} {
+		expr, err := stmt.Render(context.Background(), c.param)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expr.SQL != c.expected || len(expr.Args) != c.args {
+			t.Fatal(expr)
+		}
+	}
+
+	empty := sqlt.Stmt[struct{}](
+		sqlt.Parse(`SELECT id FROM books {{ $w := Where }}{{ $w.SQL }}`),
+	)
+
+	expr, err := empty.Render(context.Background(), struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expr.SQL != "SELECT id FROM books" {
+		t.Fatal(expr.SQL)
+	}
+}
+
+func TestExecID(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectExec("INSERT INTO books (title) VALUES (?)").WithArgs("A").WillReturnResult(sqlmock.NewResult(42, 1))
+	mock.ExpectExec("INSERT INTO books (title) VALUES (?)").WithArgs("B").WillReturnResult(sqlmock.NewErrorResult(errors.New("unsupported")))
+
+	stmt := sqlt.Stmt[string](
+		sqlt.Parse(`INSERT INTO books (title) VALUES ({{ . }})`),
+	)
+
+	id, err := stmt.ExecID(context.Background(), db, "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id != 42 {
+		t.Fatal(id)
+	}
+
+	if _, err = stmt.ExecID(context.Background(), db, "B"); err == nil || !strings.Contains(err.Error(), "use ExecReturning: unsupported") {
+		t.Fatal(err)
+	}
+}
+
+var errDuplicate = errors.New("duplicate book")
+
+func TestOnError(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectExec("INSERT INTO books (title) VALUES (?)").WithArgs("A").WillReturnError(errors.New("SQLSTATE 23505"))
+	mock.ExpectQuery("SELECT title FROM books").WillReturnError(errors.New("SQLSTATE 23505"))
+
+	var (
+		logged []error
+		calls  int
+	)
+
+	config := sqlt.Config{
+		End: func(err error, runner *sqlt.Runner) {
+			logged = append(logged, err)
+		},
+		OnError: func(err error, runner *sqlt.Runner) error {
+			calls++
+
+			if strings.Contains(err.Error(), "23505") {
+				return fmt.Errorf("%w: %w", errDuplicate, err)
+			}
+
+			return err
+		},
+	}
+
+	stmt := sqlt.Stmt[string](
+		config,
+		sqlt.Parse(`INSERT INTO books (title) VALUES ({{ . }})`),
+	)
+
+	if _, err = stmt.Exec(context.Background(), db, "A"); !errors.Is(err, errDuplicate) {
+		t.Fatal(err)
+	}
+
+	query := sqlt.QueryStmt[struct{}, string](
+		config,
+		sqlt.Parse(`SELECT title FROM books`),
+	)
+
+	for _, err = range query.Iter(context.Background(), db, struct{}{}) {
+		if !errors.Is(err, errDuplicate) {
+			t.Fatal(err)
+		}
+	}
+
+	if len(logged) != 2 || errors.Is(logged[0], errDuplicate) || errors.Is(logged[1], errDuplicate) {
+		t.Fatal(logged)
+	}
+
+	if calls != 2 {
+		t.Fatal(calls)
+	}
+}
+
+func TestIsDialect(t *testing.T) {
+	for _, c := range []struct {
+		config   sqlt.Config
+		expected string
+	}{
+		{sqlt.Postgres(), "SELECT id FROM books ORDER BY id LIMIT 1"},
+		{sqlt.Sqlite(), "SELECT id FROM books ORDER BY id LIMIT 1"},
+		{sqlt.SQLServer(), "SELECT TOP 1 id FROM books ORDER BY id"},
+		{sqlt.MySQL(), "SELECT id FROM books ORDER BY id LIMIT 1 -- mysql"},
+	} {
+		stmt := sqlt.Stmt[struct{}](
+			c.config,
+			sqlt.Parse(`SELECT {{ if IsSQLServer }}TOP 1 {{ end }}id FROM books ORDER BY id{{ if or IsPostgres IsSqlite IsMySQL }} LIMIT 1{{ end }}{{ if IsMySQL }} -- mysql{{ end }}`),
+		)
+
+		expr, err := stmt.Render(context.Background(), struct{}{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expr.SQL != c.expected {
+			t.Fatal(expr.SQL)
+		}
+	}
+}
+
+func TestRequireAllFields(t *testing.T) {
+	type Book struct {
+		ID     int64
+		Title  string
+		Cached bool `sqlt:"optional"`
+	}
+
+	_ = sqlt.QueryStmt[struct{}, Book](
+		sqlt.RequireAllFields(true),
+		sqlt.Parse(`SELECT {{ ScanInt64 Dest.ID "id" }}{{ if true }}, {{ ScanString Book.Title "title" }}{{ end }} FROM books`),
+	)
+
+	_ = sqlt.QueryStmt[struct{}, Book](
+		sqlt.RequireAllFields(true),
+		sqlt.Parse(`SELECT {{ ScanAll Dest "id AS ID, title AS Title" }} FROM books`),
+	)
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "are not used: Title") {
+			t.Fatal(r)
+		}
+	}()
+
+	_ = sqlt.QueryStmt[struct{}, Book](
+		sqlt.RequireAllFields(true),
+		sqlt.Parse(`SELECT {{ ScanInt64 Dest.ID "id" }} FROM books`),
+	)
+}
+
+func TestExecEach(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type Book struct {
+		ID    int64
+		Title string
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE books SET title = ? WHERE id = ?").WithArgs("A", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM books WHERE id = ?").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE books SET title = ? WHERE id = ?").WithArgs("C", 3).WillReturnError(errors.New("locked"))
+	mock.ExpectRollback()
+
+	var ends int
+
+	stmt := sqlt.Stmt[Book](
+		sqlt.End(func(err error, runner *sqlt.Runner) {
+			ends++
+		}),
+		sqlt.Parse(`{{ if .Title }}UPDATE books SET title = {{ .Title }} WHERE id = {{ .ID }}{{ else }}DELETE FROM books WHERE id = {{ .ID }}{{ end }}`),
+	)
+
+	var results []sql.Result
+
+	err = sqlt.InTx(context.Background(), nil, db, func(tx sqlt.DB) error {
+		results, err = stmt.ExecEach(context.Background(), tx, []Book{{1, "A"}, {2, ""}, {3, "C"}, {4, "D"}})
+
+		return err
+	})
+	if err == nil || err.Error() != "locked" {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 || ends != 3 {
+		t.Fatal(results, ends)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectExec("DELETE FROM books WHERE id = ?").WithArgs(1).WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	var (
+		ctxErr error
+		runCtx context.Context
+	)
+
+	stmt := sqlt.Stmt[int64](
+		sqlt.Timeout(10*time.Millisecond),
+		sqlt.End(func(err error, runner *sqlt.Runner) {
+			ctxErr = runner.Context.Err()
+			runCtx = runner.Context
+		}),
+		sqlt.Parse(`DELETE FROM books WHERE id = {{ . }}`),
+	)
+
+	if _, err = stmt.Exec(context.Background(), db, 1); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if !errors.Is(ctxErr, context.DeadlineExceeded) {
+		t.Fatal(ctxErr)
+	}
+
+	if _, err = stmt.Query(context.Background(), db, 2); err == nil || !strings.Contains(err.Error(), "Timeout is not supported by Query") {
+		t.Fatal(err)
+	}
+
+	if _, err = stmt.QueryRow(context.Background(), db, 3); err == nil || !strings.Contains(err.Error(), "Timeout is not supported by QueryRow") {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("DELETE FROM books WHERE id = ?").WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+
+	if err = stmt.QueryFunc(context.Background(), db, 4, func(rows *sql.Rows) error {
+		for rows.Next() {
+		}
+
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ctxErr != nil || !errors.Is(runCtx.Err(), context.Canceled) {
+		t.Fatal(ctxErr, runCtx.Err())
+	}
+}
+
+type testPoint struct {
+	X, Y int
+}
+
+func (p testPoint) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("(%d,%d)", p.X, p.Y)), nil
+}
+
+type testTags struct {
+	Names []string
+}
+
+func (t testTags) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.Names)
+}
+
+func TestMarshalArgs(t *testing.T) {
+	type Param struct {
+		Point testPoint
+		Tags  testTags
+		Title sql.NullString
+	}
+
+	stmt := sqlt.Stmt[Param](
+		sqlt.MarshalArgs(true),
+		sqlt.Parse(`INSERT INTO books (point, tags, title) VALUES ({{ .Point }}, {{ .Tags }}, {{ .Title }})`),
+	)
+
+	expr, err := stmt.Render(context.Background(), Param{testPoint{1, 2}, testTags{[]string{"go"}}, sql.NullString{String: "A", Valid: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expr.Args[0] != "(1,2)" || expr.Args[1] != `["go"]` || expr.Args[2] != (sql.NullString{String: "A", Valid: true}) {
+		t.Fatal(expr.Args)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	stmt := sqlt.QueryStmt[string, string](
+		sqlt.New("query"),
+		sqlt.Parse(`
+			{{ define "where" }}WHERE author = {{ . }}{{ end }}
+			{{ define "count" }}SELECT COUNT(*) FROM books {{ template "where" . }}{{ end }}
+			SELECT title FROM books {{ template "where" . }}
+		`),
+	)
+
+	if names := stmt.Templates(); strings.Join(names, ",") != "count,query,where" {
+		t.Fatal(names)
+	}
+
+	if names := sqlt.Stmt[string](sqlt.Parse(`SELECT 1`)).Templates(); len(names) != 0 {
+		t.Fatal(names)
+	}
+}
+
+func TestInSavepoint(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SAVEPOINT first").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM books WHERE id = ?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("RELEASE SAVEPOINT first").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SAVEPOINT second").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM books WHERE id = ?").WithArgs(2).WillReturnError(errors.New("locked"))
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT second").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	stmt := sqlt.Stmt[int64](
+		sqlt.Parse(`DELETE FROM books WHERE id = {{ . }}`),
+	)
+
+	err = sqlt.InTx(context.Background(), nil, db, func(tx sqlt.DB) error {
+		if err := sqlt.InSavepoint(context.Background(), tx, "Postgres", "first", func(db sqlt.DB) error {
+			_, err := stmt.Exec(context.Background(), db, 1)
+
+			return err
+		}); err != nil {
+			return err
+		}
+
+		if err := sqlt.InSavepoint(context.Background(), tx, "Postgres", "second", func(db sqlt.DB) error {
+			_, err := stmt.Exec(context.Background(), db, 2)
+
+			return err
+		}); err == nil || err.Error() != "locked" {
+			return fmt.Errorf("unexpected error: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanJSONReflect(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT address, tags FROM authors").
+		WillReturnRows(sqlmock.NewRows([]string{"address", "tags"}).AddRow(`{"city":"Berlin"}`, `["a","b"]`).AddRow(nil, `[]`))
+	mock.ExpectQuery("SELECT address, tags FROM authors").
+		WillReturnRows(sqlmock.NewRows([]string{"address", "tags"}).AddRow(`{"city":"Berlin","zip":"10115"}`, `[]`))
+
+	type Address struct {
+		City string `json:"city"`
+	}
+
+	type Author struct {
+		Address Address
+		Tags    []string
+	}
+
+	stmt := sqlt.QueryStmt[struct{}, Author](
+		sqlt.Parse(`SELECT {{ ScanJSON Dest.Address "address" }}, {{ ScanJSON Dest.Tags "tags" }} FROM authors`),
+	)
+
+	authors, err := stmt.All(context.Background(), db, struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(authors) != 2 || authors[0].Address.City != "Berlin" || len(authors[0].Tags) != 2 || authors[1].Address.City != "" {
+		t.Fatal(authors)
+	}
+
+	strict := sqlt.QueryStmt[struct{}, Author](
+		sqlt.Parse(`SELECT {{ ScanJSONStrict Dest.Address "address" }}, {{ ScanJSONStrict Dest.Tags "tags" }} FROM authors`),
+	)
+
+	if _, err = strict.All(context.Background(), db, struct{}{}); err == nil || !strings.Contains(err.Error(), "unknown field") {
+		t.Fatal(err)
+	}
+}
+
+func TestCompactParens(t *testing.T) {
+	stmt := sqlt.Stmt[[]int](
+		sqlt.CompactParens(true),
+		sqlt.Parse(`SELECT id FROM books WHERE id IN ( {{ In . }} ) AND title <> '( a )' AND (
+			price > {{ 10 }}
+		)`),
+	)
+
+	expr, err := stmt.Render(context.Background(), []int{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expr.SQL != "SELECT id FROM books WHERE id IN (?, ?) AND title <> '( a )' AND (price > ?)" {
+		t.Fatal(expr.SQL)
+	}
+}
+
+func TestCustom(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT title FROM books").
+		WillReturnRows(sqlmock.NewRows([]string{"title"}).AddRow("A").AddRow("B").AddRow("C"))
+
+	stmt := sqlt.QueryStmt[struct{}, string](
+		sqlt.Parse(`SELECT {{ ScanString Dest "title" }} FROM books`),
+	)
+
+	var (
+		columns []string
+		titles  []string
+	)
+
+	err = stmt.Custom(context.Background(), db, struct{}{}, func(rows *sql.Rows, scan func() (string, error)) error {
+		types, err := rows.ColumnTypes()
+		if err != nil {
+			return err
+		}
+
+		for _, ct := range types {
+			columns = append(columns, ct.Name())
+		}
+
+		for rows.Next() && len(titles) < 2 {
+			title, err := scan()
+			if err != nil {
+				return err
+			}
+
+			titles = append(titles, title)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(columns, ",") != "title" || strings.Join(titles, ",") != "A,B" {
+		t.Fatal(columns, titles)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	type Param struct {
+		Config  map[string]any
+		Invalid chan int
+	}
+
+	stmt := sqlt.Stmt[Param](
+		sqlt.Postgres(),
+		sqlt.Parse(`SELECT configure({{ JSON .Config }}::jsonb)`),
+	)
+
+	expr, err := stmt.Render(context.Background(), Param{Config: map[string]any{"a": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expr.SQL != "SELECT configure($1::jsonb)" || expr.Args[0] != `{"a":1}` {
+		t.Fatal(expr)
+	}
+
+	invalid := sqlt.Stmt[Param](
+		sqlt.Parse(`SELECT configure({{ JSON .Invalid }})`),
+	)
+
+	if _, err = invalid.Render(context.Background(), Param{Invalid: make(chan int)}); err == nil || !strings.Contains(err.Error(), "unsupported type") {
+		t.Fatal(err)
+	}
+}
+
+func TestTwo(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type Book struct {
+		ID    int64 `sql:"id"`
+		Title string
+	}
+
+	mock.ExpectQuery("CALL books_and_authors(?)").WithArgs(1).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "Title"}).AddRow(1, "A").AddRow(2, "B"),
+			sqlmock.NewRows([]string{"name"}).AddRow("Rowling"),
+		)
+
+	stmt := sqlt.Stmt[int64](
+		sqlt.Parse(`CALL books_and_authors({{ . }})`),
+	)
+
+	books, authors, err := sqlt.Two[Book, string](context.Background(), stmt, db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(books) != 2 || books[1].Title != "B" || len(authors) != 1 || authors[0] != "Rowling" {
+		t.Fatal(books, authors)
+	}
+}
+
+func TestExpressionKey(t *testing.T) {
+	type Param struct {
+		ID     int64
+		Unused string
+	}
+
+	stmt := sqlt.Stmt[Param](
+		sqlt.Parse(`SELECT title FROM books WHERE id = {{ .ID }}`),
+	)
+
+	render := func(param Param) uint64 {
+		expr, err := stmt.Render(context.Background(), param)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return expr.Key()
+	}
+
+	if render(Param{ID: 1, Unused: "a"}) != render(Param{ID: 1, Unused: "b"}) {
+		t.Fatal("expected equal keys")
+	}
+
+	if render(Param{ID: 1}) == render(Param{ID: 2}) {
+		t.Fatal("expected different keys")
+	}
+
+	if (sqlt.Expression{SQL: "SELECT ?", Args: []any{1}}).Key() == (sqlt.Expression{SQL: "SELECT ?", Args: []any{"1"}}).Key() {
+		t.Fatal("expected different keys for different types")
+	}
+
+	now := time.Now()
+	id, other := int64(1), int64(1)
+
+	if (sqlt.Expression{SQL: "SELECT ?, ?", Args: []any{now, &id}}).Key() !=
+		(sqlt.Expression{SQL: "SELECT ?, ?", Args: []any{now.Round(0).In(time.FixedZone("X", 3600)), &other}}).Key() {
+		t.Fatal("expected equal keys for equal times and pointers to equal values")
+	}
+}
+
+func TestBindField(t *testing.T) {
+	type Param struct {
+		Columns []string
+		Title   string
+		Author  string
+	}
+
+	stmt := sqlt.Stmt[*Param](
+		sqlt.Dollar(),
+		sqlt.Parse(`UPDATE books SET {{ range $i, $c := .Columns }}{{ if $i }}, {{ end }}{{ Raw $c }} = {{ Bind $c }}{{ end }}`),
+	)
+
+	expr, err := stmt.Render(context.Background(), &Param{Columns: []string{"Title", "Author"}, Title: "A", Author: "B"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expr.SQL != "UPDATE books SET Title = $1, Author = $2" || expr.Args[0] != "A" || expr.Args[1] != "B" {
+		t.Fatal(expr)
+	}
+
+	if _, err = stmt.Render(context.Background(), &Param{Columns: []string{"Price"}}); err == nil || !strings.Contains(err.Error(), "field 'Price' not found") {
+		t.Fatal(err)
+	}
+}
+
+func TestParseWithFuncs(t *testing.T) {
+	stmt := sqlt.Stmt[int64](
+		sqlt.ParseWithFuncs(`DELETE FROM {{ Table }} WHERE id = {{ . }}`, template.FuncMap{
+			"Table": func() sqlt.Raw {
+				return "books"
+			},
+		}),
+	)
+
+	expr, err := stmt.Render(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expr.SQL != "DELETE FROM books WHERE id = ?" {
+		t.Fatal(expr.SQL)
+	}
+}
+
+func TestScanPGArray(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT array_agg(tag) FROM tags GROUP BY book_id").
+		WillReturnRows(sqlmock.NewRows([]string{"tags"}).
+			AddRow(`{a,"b,c","d \"e\"",NULL,"NULL"}`).
+			AddRow(`{}`).
+			AddRow(nil))
+	mock.ExpectQuery("SELECT array_agg(tag) FROM tags GROUP BY book_id").
+		WillReturnRows(sqlmock.NewRows([]string{"tags"}).AddRow(`{{a},{b}}`))
+
+	stmt := sqlt.QueryStmt[struct{}, []string](
+		sqlt.Parse(`SELECT {{ ScanPGArray Dest "array_agg(tag)" }} FROM tags GROUP BY book_id`),
+	)
+
+	tags, err := stmt.All(context.Background(), db, struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tags) != 3 || strings.Join(tags[0], "|") != `a|b,c|d "e"||NULL` || tags[1] == nil || len(tags[1]) != 0 || tags[2] != nil {
+		t.Fatal(tags)
+	}
+
+	if _, err = stmt.All(context.Background(), db, struct{}{}); err == nil || !strings.Contains(err.Error(), "multi-dimensional") {
+		t.Fatal(err)
+	}
+}
+
+func TestSlogLogger(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT title FROM books WHERE id = ?").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"title"}).AddRow("A"))
+	mock.ExpectQuery("SELECT title FROM books WHERE id = ?").WithArgs(2).
+		WillReturnError(errors.New("boom"))
+
+	var (
+		buf   strings.Builder
+		ended int
+	)
+
+	stmt := sqlt.QueryStmt[int64, string](
+		sqlt.End(func(err error, runner *sqlt.Runner) {
+			ended++
+		}),
+		sqlt.SlogLogger(slog.New(slog.NewJSONHandler(&buf, nil)), slog.LevelInfo),
+		sqlt.Parse(`SELECT title FROM books WHERE id = {{ . }}`),
+	)
+
+	if _, err = stmt.First(context.Background(), db, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = stmt.All(context.Background(), db, 2); err == nil {
+		t.Fatal("expected error")
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || ended != 2 {
+		t.Fatal(ended, buf.String())
+	}
+
+	var record map[string]any
+
+	if err = json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+
+	if record["sql"] != "SELECT title FROM books WHERE id = ?" || record["args"] != float64(1) ||
+		record["prepared"] != false || record["values"] != nil || record["error"] != nil ||
+		!strings.Contains(record["location"].(string), "sqlt_test.go") {
+		t.Fatal(record)
+	}
+
+	if err = json.Unmarshal([]byte(lines[1]), &record); err != nil {
+		t.Fatal(err)
+	}
+
+	if record["error"] != "boom" {
+		t.Fatal(record)
+	}
+
+	mock.ExpectExec("DELETE FROM books WHERE id = ?").WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	buf.Reset()
+
+	if _, err = sqlt.Stmt[int64](
+		sqlt.SlogLoggerWithArgs(slog.New(slog.NewJSONHandler(&buf, nil)), slog.LevelInfo),
+		sqlt.Parse(`DELETE FROM books WHERE id = {{ . }}`),
+	).Exec(context.Background(), db, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), `"values":[3]`) {
+		t.Fatal(buf.String())
+	}
+}
+
+func TestValidateParam(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT title FROM books WHERE id IN (?, ?)").WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"title"}).AddRow("A").AddRow("B"))
+
+	errEmpty := errors.New("empty ids")
+
+	stmt := sqlt.QueryStmt[[]int64, string](
+		sqlt.ValidateParam(func(param any) error {
+			if len(param.([]int64)) == 0 {
+				return errEmpty
+			}
+
+			return nil
+		}),
+		sqlt.Parse(`SELECT title FROM books WHERE id IN ({{ range $i, $id := . }}{{ if $i }}, {{ end }}{{ $id }}{{ end }})`),
+	)
+
+	titles, err := stmt.All(context.Background(), db, []int64{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(titles) != 2 {
+		t.Fatal(titles)
+	}
+
+	_, err = stmt.All(context.Background(), db, nil)
+	if !errors.Is(err, errEmpty) || !strings.HasPrefix(err.Error(), "location: [") {
+		t.Fatal(err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestErrNotFound(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT title FROM books WHERE id = ?").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"title"}))
+	mock.ExpectQuery("SELECT title FROM books WHERE id = ?").WithArgs(2).
+		WillReturnError(errors.New("boom"))
+
+	stmt := sqlt.QueryStmt[int64, string](
+		sqlt.Parse(`SELECT title FROM books WHERE id = {{ . }}`),
+	)
+
+	_, err = stmt.First(context.Background(), db, 1)
+	if !errors.Is(err, sqlt.ErrNotFound) || !errors.Is(err, sql.ErrNoRows) {
+		t.Fatal(err)
+	}
+
+	_, err = stmt.First(context.Background(), db, 2)
+	if err == nil || errors.Is(err, sqlt.ErrNotFound) {
+		t.Fatal(err)
+	}
+}
+
+func TestLike(t *testing.T) {
+	for _, c := range []struct {
+		dialect sqlt.Dialect
+		mode    string
+		term    string
+		sql     string
+		arg     string
+	}{
+		{dialect: "Sqlite", mode: "contains", term: `50%_off\`, sql: `SELECT id FROM books WHERE title LIKE ? ESCAPE '\'`, arg: `%50\%\_off\\%`},
+		{dialect: "Postgres", mode: "prefix", term: "a_b", sql: "SELECT id FROM books WHERE title LIKE $1", arg: `a\_b%`},
+		{dialect: "MySQL", mode: "suffix", term: "a%", sql: "SELECT id FROM books WHERE title LIKE ?", arg: `%a\%`},
+		{dialect: "SQLServer", mode: "contains", term: "[a]", sql: `SELECT id FROM books WHERE title LIKE @p1 ESCAPE '\'`, arg: `%\[a]%`},
+	} {
+		expr, err := sqlt.Stmt[string](
+			c.dialect,
+			sqlt.Parse(`SELECT id FROM books WHERE title LIKE {{ Like . "`+c.mode+`" }}`),
+		).Render(context.Background(), c.term)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expr.SQL != c.sql || len(expr.Args) != 1 || expr.Args[0] != c.arg {
+			t.Fatal(c.dialect, expr.SQL, expr.Args)
+		}
+	}
+
+	_, err := sqlt.Stmt[string](
+		sqlt.Parse(`SELECT id FROM books WHERE title LIKE {{ Like . "infix" }}`),
+	).Render(context.Background(), "a")
+	if err == nil || !strings.Contains(err.Error(), "invalid like mode 'infix'") {
+		t.Fatal(err)
+	}
+}
+
+func TestAllWithTotal(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT id, title, COUNT(*) OVER() AS total_count FROM books LIMIT ?").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "total_count"}).AddRow(1, "A", 5).AddRow(2, "B", 5))
+	mock.ExpectQuery("SELECT id, title, COUNT(*) OVER() AS total_count FROM books LIMIT ?").WithArgs(0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "total_count"}))
+
+	type Book struct {
+		ID    int64
+		Title string
+	}
+
+	stmt := sqlt.QueryStmt[int64, Book](
+		sqlt.Parse(`SELECT {{ ScanInt64 Dest.ID "id" }}, {{ ScanString Dest.Title "title" }}, {{ TotalCount }} FROM books LIMIT {{ . }}`),
+	)
+
+	books, total, err := stmt.AllWithTotal(context.Background(), db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if total != 5 || len(books) != 2 || books[0].Title != "A" || books[1].ID != 2 {
+		t.Fatal(books, total)
+	}
+
+	books, total, err = stmt.AllWithTotal(context.Background(), db, 0)
+	if err != nil || total != 0 || len(books) != 0 {
+		t.Fatal(books, total, err)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT title FROM tenant_a.books WHERE id = ? /* no-cache */").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"title"}).AddRow("A"))
+
+	type Key struct{}
+
+	stmt := sqlt.QueryStmt[int64, string](
+		sqlt.Rewrite(func(ctx context.Context, sql string, args []any) (string, []any, error) {
+			return strings.ReplaceAll(sql, "tenant.", ctx.Value(Key{}).(string)+"."), args, nil
+		}),
+		sqlt.Rewrite(func(ctx context.Context, sql string, args []any) (string, []any, error) {
+			return sql + " /* no-cache */", args, nil
+		}),
+		sqlt.Parse(`SELECT title FROM tenant.books WHERE id = {{ . }}`),
+	)
+
+	ctx := context.WithValue(context.Background(), Key{}, "tenant_a")
+
+	title, err := stmt.First(ctx, db, 1)
+	if err != nil || title != "A" {
+		t.Fatal(title, err)
+	}
+
+	expr, err := stmt.Render(ctx, 1)
+	if err != nil || expr.SQL != "SELECT title FROM tenant.books WHERE id = ?" {
+		t.Fatal(expr, err)
+	}
+
+	_, err = sqlt.Stmt[int64](
+		sqlt.Rewrite(func(ctx context.Context, sql string, args []any) (string, []any, error) {
+			return "", nil, errors.New("rejected")
+		}),
+		sqlt.Parse(`DELETE FROM books WHERE id = {{ . }}`),
+	).Exec(ctx, db, 1)
+	if err == nil || err.Error() != "rejected" {
+		t.Fatal(err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTable(t *testing.T) {
+	type Key struct{}
+
+	stmt := sqlt.Stmt[int64](
+		sqlt.MySQL(),
+		sqlt.Schema(func(ctx context.Context) string {
+			schema, _ := ctx.Value(Key{}).(string)
+
+			return schema
+		}),
+		sqlt.Parse(`SELECT name FROM {{ Table "users" }} WHERE id = {{ . }}`),
+	)
+
+	expr, err := stmt.Render(context.WithValue(context.Background(), Key{}, "tenant_42"), 1)
+	if err != nil || expr.SQL != "SELECT name FROM `tenant_42`.`users` WHERE id = ?" {
+		t.Fatal(expr, err)
+	}
+
+	expr, err = stmt.Render(context.Background(), 1)
+	if err != nil || expr.SQL != "SELECT name FROM `users` WHERE id = ?" {
+		t.Fatal(expr, err)
+	}
+
+	_, err = stmt.Render(context.WithValue(context.Background(), Key{}, "x; DROP TABLE users"), 1)
+	if err == nil || !strings.Contains(err.Error(), "invalid schema name") {
+		t.Fatal(err)
+	}
+}
+
+func TestUnsafeReuseArgs(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectExec("DELETE FROM books WHERE id = ? AND tenant = ?").WithArgs(1, "a").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM books WHERE id = ? AND tenant = ?").WithArgs(2, "a").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	stmt := sqlt.Stmt[int64](
+		sqlt.UnsafeReuseArgs(true),
+		sqlt.Rewrite(func(ctx context.Context, sql string, args []any) (string, []any, error) {
+			return sql + " AND tenant = ?", append(args, "a"), nil
+		}),
+		sqlt.Parse(`DELETE FROM books WHERE id = {{ . }}`),
+	)
+
+	for _, id := range []int64{1, 2} {
+		if _, err = stmt.Exec(context.Background(), db, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testDecimal struct {
+	units int64
+	scale int
+}
+
+func parseTestDecimal(text string) (testDecimal, error) {
+	whole, frac, _ := strings.Cut(text, ".")
+
+	var units int64
+
+	if _, err := fmt.Sscan(whole+frac, &units); err != nil {
+		return testDecimal{}, err
+	}
+
+	return testDecimal{units: units, scale: len(frac)}, nil
+}
+
+func TestTextScanner(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT price, discount FROM books").
+		WillReturnRows(sqlmock.NewRows([]string{"price", "discount"}).AddRow("12.340", nil).AddRow(nil, "0.5"))
+	mock.ExpectQuery("SELECT price, discount FROM books").
+		WillReturnRows(sqlmock.NewRows([]string{"price", "discount"}).AddRow("abc", nil))
+
+	type Book struct {
+		Price    testDecimal
+		Discount *testDecimal
+	}
+
+	stmt := sqlt.QueryStmt[struct{}, Book](
+		sqlt.ScanFuncs(map[string]func(dest any, str string, args ...any) (sqlt.Scanner, error){
+			"Decimal": sqlt.TextScanner(parseTestDecimal),
+		}),
+		sqlt.Parse(`SELECT {{ ScanDecimal Dest.Price "price" }}, {{ ScanDecimal Dest.Discount "discount" }} FROM books`),
+	)
+
+	books, err := stmt.All(context.Background(), db, struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(books) != 2 || books[0].Price != (testDecimal{units: 12340, scale: 3}) || books[0].Discount != nil ||
+		books[1].Price != (testDecimal{}) || *books[1].Discount != (testDecimal{units: 5, scale: 1}) {
+		t.Fatal(books)
+	}
+
+	if _, err = stmt.All(context.Background(), db, struct{}{}); err == nil || !strings.Contains(err.Error(), "column 'price'") {
+		t.Fatal(err)
+	}
+
+	_, err = sqlt.QueryStmt[struct{}, string](
+		sqlt.ScanFuncs(map[string]func(dest any, str string, args ...any) (sqlt.Scanner, error){
+			"Decimal": sqlt.TextScanner(parseTestDecimal),
+		}),
+		sqlt.Parse(`SELECT {{ ScanDecimal Dest "price" }} FROM books`),
+	).Render(context.Background(), struct{}{})
+	if err == nil || !strings.Contains(err.Error(), "expected *sqlt_test.testDecimal") {
+		t.Fatal(err)
+	}
+}
+
+func TestPrependArgs(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectExec("SELECT set_config('app.tenant', $1, true); DELETE FROM books WHERE id = $2").
+		WithArgs("42", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	type Key struct{}
+
+	stmt := sqlt.Stmt[int64](
+		sqlt.Postgres(),
+		sqlt.PrependArgs(func(ctx context.Context) []any {
+			return []any{ctx.Value(Key{})}
+		}),
+		sqlt.Parse(`SELECT set_config('app.tenant', $1, true); DELETE FROM books WHERE id = {{ . }}`),
+	)
+
+	ctx := context.WithValue(context.Background(), Key{}, "42")
+
+	if _, err = stmt.Exec(ctx, db, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	expr, err := stmt.Render(ctx, 2)
+	if err != nil || len(expr.Args) != 2 || expr.Args[0] != "42" || expr.Args[1] != int64(2) {
+		t.Fatal(expr, err)
+	}
+}
+
+func TestEncodeJSON(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT id, title FROM books").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(1, "A").AddRow(2, "B"))
+	mock.ExpectQuery("SELECT id, title FROM books").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title"}))
+	mock.ExpectQuery("SELECT id, title FROM books").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(1, "A").AddRow(2, "B").RowError(1, errors.New("boom")))
+
+	type Book struct {
+		ID    int64  `json:"id"`
+		Title string `json:"title"`
+	}
+
+	stmt := sqlt.QueryStmt[struct{}, Book](
+		sqlt.Parse(`SELECT {{ ScanInt64 Dest.ID "id" }}, {{ ScanString Dest.Title "title" }} FROM books`),
+	)
+
+	var buf strings.Builder
+
+	if err = stmt.EncodeJSON(context.Background(), db, struct{}{}, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != `[{"id":1,"title":"A"},{"id":2,"title":"B"}]` {
+		t.Fatal(buf.String())
+	}
+
+	buf.Reset()
+
+	if err = stmt.EncodeJSON(context.Background(), db, struct{}{}, &buf); err != nil || buf.String() != "[]" {
+		t.Fatal(buf.String(), err)
+	}
+
+	buf.Reset()
+
+	if err = stmt.EncodeJSON(context.Background(), db, struct{}{}, &buf); err == nil || err.Error() != "boom" ||
+		buf.String() != `[{"id":1,"title":"A"}` {
+		t.Fatal(buf.String(), err)
+	}
+}
+
+type testArray struct {
+	list any
+}
+
+func TestAny(t *testing.T) {
+	stmt := sqlt.Stmt[[]int64](
+		sqlt.Postgres(),
+		sqlt.ArrayArg(func(list any) any {
+			return testArray{list: list}
+		}),
+		sqlt.Parse(`SELECT title FROM books WHERE id {{ Any . }}`),
+	)
+
+	expr, err := stmt.Render(context.Background(), []int64{1, 2})
+	if err != nil || expr.SQL != "SELECT title FROM books WHERE id = ANY($1)" || len(expr.Args) != 1 {
+		t.Fatal(expr, err)
+	}
+
+	if arg, ok := expr.Args[0].(testArray); !ok || len(arg.list.([]int64)) != 2 {
+		t.Fatal(expr.Args)
+	}
+
+	stmt = sqlt.Stmt[[]int64](
+		sqlt.Sqlite(),
+		sqlt.Parse(`SELECT title FROM books WHERE id {{ Any . }}`),
+	)
+
+	expr, err = stmt.Render(context.Background(), []int64{1, 2})
+	if err != nil || expr.SQL != "SELECT title FROM books WHERE id IN (?, ?)" || len(expr.Args) != 2 {
+		t.Fatal(expr, err)
+	}
+
+	expr, err = stmt.Render(context.Background(), nil)
+	if err != nil || expr.SQL != "SELECT title FROM books WHERE id IN (NULL)" || len(expr.Args) != 0 {
+		t.Fatal(expr, err)
+	}
+}
+
+func TestInclude(t *testing.T) {
+	fragments := sqlt.Config{
+		TemplateOptions: []sqlt.TemplateOption{
+			sqlt.Funcs(template.FuncMap{
+				"Active": func() sqlt.Raw {
+					return "deleted_at IS NULL"
+				},
+			}),
+			sqlt.Parse(`
+				{{ define "columns" }}id, title{{ end }}
+				{{ define "where" }}WHERE {{ Active }} AND author = {{ . }}{{ end }}
+			`),
+		},
+	}
+
+	stmt := sqlt.Stmt[string](
+		sqlt.Include(fragments),
+		sqlt.Parse(`SELECT {{ template "columns" }} FROM books {{ template "where" . }}`),
+	)
+
+	expr, err := stmt.Render(context.Background(), "Tolkien")
+	if err != nil || expr.SQL != "SELECT id, title FROM books WHERE deleted_at IS NULL AND author = ?" ||
+		len(expr.Args) != 1 || expr.Args[0] != "Tolkien" {
+		t.Fatal(expr, err)
+	}
+
+	if names := stmt.Templates(); strings.Join(names, ",") != "columns,where" {
+		t.Fatal(names)
+	}
+
+	expr, err = sqlt.Stmt[string](
+		sqlt.Parse(`SELECT {{ template "columns" }} FROM books`),
+		sqlt.Include(fragments),
+	).Render(context.Background(), "")
+	if err != nil || expr.SQL != "SELECT id, title FROM books" {
+		t.Fatal(expr, err)
+	}
+}
+
+func TestCtx(t *testing.T) {
+	type Key struct{}
+
+	stmt := sqlt.QueryStmt[string, int64](
+		sqlt.Parse(`SELECT {{ ScanInt64 Dest "id" }} FROM books WHERE title = {{ . }}{{ if Ctx "tenant" }} AND tenant = {{ Ctx "tenant" }}{{ end }}`),
+	)
+
+	ctx := context.WithValue(context.Background(), sqlt.ContextKey("tenant"), 42)
+
+	expr, err := stmt.Render(context.WithValue(ctx, Key{}, "secret"), "A")
+	if err != nil || expr.SQL != "SELECT id FROM books WHERE title = ? AND tenant = ?" || len(expr.Args) != 2 || expr.Args[1] != 42 {
+		t.Fatal(expr, err)
+	}
+
+	expr, err = sqlt.Stmt[string](
+		sqlt.Parse(`DELETE FROM books WHERE title = {{ . }}{{ if Ctx "tenant" }} AND tenant = {{ Ctx "tenant" }}{{ end }}`),
+	).Render(context.WithValue(context.Background(), Key{}, 42), "A")
+	if err != nil || expr.SQL != "DELETE FROM books WHERE title = ?" || len(expr.Args) != 1 {
+		t.Fatal(expr, err)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("EXPLAIN (ANALYZE, FORMAT TEXT) SELECT title FROM books WHERE id = $1").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow("Index Scan using books_pkey on books").AddRow("Execution Time: 0.1 ms"))
+	mock.ExpectQuery("EXPLAIN QUERY PLAN DELETE FROM books WHERE id = ?").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "parent", "notused", "detail"}).AddRow(2, 0, 0, "SEARCH books USING INTEGER PRIMARY KEY (rowid=?)"))
+
+	plan, err := sqlt.QueryStmt[int64, string](
+		sqlt.Postgres(),
+		sqlt.Parse(`SELECT {{ ScanString Dest "title" }} FROM books WHERE id = {{ . }}`),
+	).Explain(context.Background(), db, 1)
+	if err != nil || plan != "Index Scan using books_pkey on books\nExecution Time: 0.1 ms" {
+		t.Fatal(plan, err)
+	}
+
+	plan, err = sqlt.Stmt[int64](
+		sqlt.Sqlite(),
+		sqlt.Parse(`DELETE FROM books WHERE id = {{ . }}`),
+	).Explain(context.Background(), db, 2)
+	if err != nil || plan != "SEARCH books USING INTEGER PRIMARY KEY (rowid=?)" {
+		t.Fatal(plan, err)
+	}
+
+	_, err = sqlt.Stmt[int64](
+		sqlt.SQLServer(),
+		sqlt.Parse(`DELETE FROM books WHERE id = {{ . }}`),
+	).Explain(context.Background(), db, 3)
+	if err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Fatal(err)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	type Book struct {
+		ID    int64
+		Title string
+	}
+
+	for _, c := range []struct {
+		config sqlt.Config
+		text   string
+		sql    string
+	}{
+		{config: sqlt.Postgres(), text: `{{ Upsert "id" "title" }}`, sql: "INSERT INTO books (id, title) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET title = EXCLUDED.title"},
+		{config: sqlt.Sqlite(), text: `{{ Upsert "id, title" }}`, sql: "INSERT INTO books (id, title) VALUES (?, ?) ON CONFLICT (id, title) DO NOTHING"},
+		{config: sqlt.MySQL(), text: `{{ Upsert "id" "title" }}`, sql: "INSERT INTO books (id, title) VALUES (?, ?) ON DUPLICATE KEY UPDATE title = VALUES(title)"},
+		{config: sqlt.MySQL(), text: `{{ Upsert "id" }}`, sql: "INSERT INTO books (id, title) VALUES (?, ?) ON DUPLICATE KEY UPDATE id = id"},
+	} {
+		expr, err := sqlt.Stmt[Book](
+			c.config,
+			sqlt.Parse(`INSERT INTO books (id, title) VALUES ({{ .ID }}, {{ .Title }}) `+c.text),
+		).Render(context.Background(), Book{ID: 1, Title: "A"})
+		if err != nil || expr.SQL != c.sql || len(expr.Args) != 2 {
+			t.Fatal(expr, err)
+		}
+	}
+
+	_, err := sqlt.Stmt[Book](
+		sqlt.SQLServer(),
+		sqlt.Parse(`INSERT INTO books (id) VALUES ({{ .ID }}) {{ Upsert "id" }}`),
+	).Render(context.Background(), Book{})
+	if err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Fatal(err)
+	}
+
+	_, err = sqlt.Stmt[Book](
+		sqlt.Parse(`INSERT INTO books (id) VALUES ({{ .ID }}) {{ Upsert "id" "title; DROP TABLE books" }}`),
+	).Render(context.Background(), Book{})
+	if err == nil || !strings.Contains(err.Error(), "invalid column name") {
+		t.Fatal(err)
+	}
+}
+
+func TestArg(t *testing.T) {
+	stmt := sqlt.Stmt[map[string]any](
+		sqlt.Postgres(),
+		sqlt.Parse(`SELECT id FROM books WHERE author_id = {{ Arg "user_id" }} AND title = {{ Arg "title" }}`),
+	)
+
+	expr, err := stmt.Render(context.Background(), map[string]any{"user_id": 42, "title": "A"})
+	if err != nil || expr.SQL != "SELECT id FROM books WHERE author_id = $1 AND title = $2" ||
+		len(expr.Args) != 2 || expr.Args[0] != 42 || expr.Args[1] != "A" {
+		t.Fatal(expr, err)
+	}
+
+	_, err = stmt.Render(context.Background(), map[string]any{"user_id": 42})
+	if err == nil || !strings.Contains(err.Error(), "key 'title' not found in param") {
+		t.Fatal(err)
+	}
+
+	_, err = sqlt.Stmt[int64](
+		sqlt.Parse(`SELECT id FROM books WHERE id = {{ Arg "id" }}`),
+	).Render(context.Background(), 1)
+	if err == nil || !strings.Contains(err.Error(), "expected map with string keys") {
+		t.Fatal(err)
+	}
+}
+
+func TestMapRow(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT first, last FROM authors").
+		WillReturnRows(sqlmock.NewRows([]string{"first", "last"}).AddRow("J.R.R.", "Tolkien").AddRow("J.K.", "Rowling"))
+	mock.ExpectQuery("SELECT first, last FROM authors").
+		WillReturnRows(sqlmock.NewRows([]string{"first", "last"}).AddRow("", "Homer"))
+
+	type Author struct {
+		First string
+		Last  string
+		Name  string
+	}
+
+	stmt := sqlt.QueryStmt[struct{}, Author](
+		sqlt.MapRow(func(dest any) error {
+			author := dest.(*Author)
+
+			if author.First == "" {
+				return errors.New("missing first name")
+			}
+
+			author.Name = author.First + " " + author.Last
+
+			return nil
+		}),
+		sqlt.Parse(`SELECT {{ ScanString Dest.First "first" }}, {{ ScanString Dest.Last "last" }} FROM authors`),
+	)
+
+	authors, err := stmt.All(context.Background(), db, struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(authors) != 2 || authors[0].Name != "J.R.R. Tolkien" || authors[1].Name != "J.K. Rowling" {
+		t.Fatal(authors)
+	}
+
+	_, err = stmt.All(context.Background(), db, struct{}{})
+	if err == nil || !strings.HasPrefix(err.Error(), "location: [") || !strings.HasSuffix(err.Error(), "missing first name") {
+		t.Fatal(err)
+	}
+}
+
+func TestAllBy(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		mock.ExpectQuery("SELECT id, title FROM books").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(1, "A").AddRow(2, "B").AddRow(1, "C"))
+	}
+
+	type Book struct {
+		ID    int64
+		Title string
+	}
+
+	stmt := sqlt.QueryStmt[struct{}, Book](
+		sqlt.Parse(`SELECT {{ ScanInt64 Dest.ID "id" }}, {{ ScanString Dest.Title "title" }} FROM books`),
+	)
+
+	id := func(b Book) int64 {
+		return b.ID
+	}
+
+	books, err := sqlt.AllBy(context.Background(), stmt, db, struct{}{}, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(books) != 2 || books[1].Title != "C" || books[2].Title != "B" {
+		t.Fatal(books)
+	}
+
+	_, err = sqlt.AllByUnique(context.Background(), stmt, db, struct{}{}, id)
+	if !errors.Is(err, sqlt.ErrDuplicateKey) || err.Error() != "duplicate key: 1" {
+		t.Fatal(err)
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT a.id, b.title FROM authors a JOIN books b ON b.author_id = a.id").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(1, "A").AddRow(2, "B").AddRow(1, "C"))
+
+	type Row struct {
+		AuthorID int64
+		Title    string
+	}
+
+	stmt := sqlt.QueryStmt[struct{}, Row](
+		sqlt.Parse(`SELECT {{ ScanInt64 Dest.AuthorID "a.id" }}, {{ ScanString Dest.Title "b.title" }} FROM authors a JOIN books b ON b.author_id = a.id`),
+	)
+
+	groups, err := sqlt.GroupBy(context.Background(), stmt, db, struct{}{}, func(r Row) int64 {
+		return r.AuthorID
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(groups) != 2 || len(groups[1]) != 2 || groups[1][0].Title != "A" || groups[1][1].Title != "C" || len(groups[2]) != 1 {
+		t.Fatal(groups)
+	}
+}
+
+func TestOutArg(t *testing.T) {
+	type Param struct {
+		Author string
+		Total  sql.NamedArg
+	}
+
+	var total int64
+
+	expr, err := sqlt.Stmt[Param](
+		sqlt.SQLServer(),
+		sqlt.Parse(`EXEC count_books @author = {{ .Author }}, @total = @total OUTPUT {{ OutArg .Total }}`),
+	).Render(context.Background(), Param{Author: "Tolkien", Total: sql.Named("total", sql.Out{Dest: &total})})
+	if err != nil || expr.SQL != "EXEC count_books @author = @p1, @total = @total OUTPUT" || len(expr.Args) != 2 {
+		t.Fatal(expr, err)
+	}
+
+	if out, ok := expr.Args[1].(sql.NamedArg); !ok || out.Name != "total" {
+		t.Fatal(expr.Args)
+	}
+}
+
+func TestCheckTemplatesJoined(t *testing.T) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatal("The code did not panic")
+		}
+
+		msg := fmt.Sprint(r)
+
+		if !strings.Contains(msg, "template 'columns' is not defined") || !strings.Contains(msg, "template 'where' is not defined") ||
+			!strings.Contains(msg, "are not used: Author") {
+			t.Fatal(msg)
+		}
+	}()
+
+	type Book struct {
+		Title  string
+		Author string
+	}
+
+	_ = sqlt.QueryStmt[string, Book](
+		sqlt.RequireAllFields(true),
+		sqlt.Parse(`SELECT {{ template "columns" }}, {{ ScanString Dest.Title "title" }} FROM books {{ if . }}{{ template "where" . }}{{ end }}`),
+	)
+}
+
+type testStatus int
+
+const (
+	testActive testStatus = iota + 1
+	testInactive
+)
+
+func TestEnumScanner(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT status FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("active").AddRow("inactive"))
+	mock.ExpectQuery("SELECT status FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("deleted"))
+
+	stmt := sqlt.QueryStmt[struct{}, testStatus](
+		sqlt.ScanFuncs(map[string]func(dest any, str string, args ...any) (sqlt.Scanner, error){
+			"Status": sqlt.EnumScanner(map[string]testStatus{"active": testActive, "inactive": testInactive}),
+		}),
+		sqlt.Parse(`SELECT {{ ScanStatus Dest "status" }} FROM users`),
+	)
+
+	statuses, err := stmt.All(context.Background(), db, struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(statuses) != 2 || statuses[0] != testActive || statuses[1] != testInactive {
+		t.Fatal(statuses)
+	}
+
+	_, err = stmt.All(context.Background(), db, struct{}{})
+	if err == nil || !strings.HasSuffix(err.Error(), "column 'status': unknown enum value 'deleted'") {
+		t.Fatal(err)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	type Param struct {
+		PageSize *int
+	}
+
+	stmt := sqlt.Stmt[Param](
+		sqlt.Parse(`SELECT id FROM books LIMIT {{ Limit .PageSize 100 20 }}`),
+	)
+
+	for _, c := range []struct {
+		size  *int
+		limit int64
+	}{
+		{size: nil, limit: 20},
+		{size: new(int), limit: 20},
+		{size: func() *int { i := 50; return &i }(), limit: 50},
+		{size: func() *int { i := 1000000; return &i }(), limit: 100},
+	} {
+		expr, err := stmt.Render(context.Background(), Param{PageSize: c.size})
+		if err != nil || expr.SQL != "SELECT id FROM books LIMIT ?" || len(expr.Args) != 1 || expr.Args[0] != c.limit {
+			t.Fatal(expr, err)
+		}
+	}
+
+	_, err := sqlt.Stmt[string](
+		sqlt.Parse(`SELECT id FROM books LIMIT {{ Limit . 100 20 }}`),
+	).Render(context.Background(), "10")
+	if err == nil || !strings.Contains(err.Error(), "expected integer") {
+		t.Fatal(err)
+	}
+}
+
+func TestScanNested(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT b.id, b.created, a.name AS Author.Name FROM books b JOIN authors a ON a.id = b.author_id").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created", "Author.Name"}).AddRow(1, "2024", "Tolkien").AddRow(3, "2025", "Pratchett"))
+	mock.ExpectQuery("SELECT b.id, a.name FROM books b JOIN authors a ON a.id = b.author_id").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(2, "Rowling"))
+
+	type Model struct {
+		ID      int64  `sql:"id"`
+		Created string `sql:"created"`
+	}
+
+	type Author struct {
+		Name string
+	}
+
+	type Book struct {
+		Model
+		Author    Author
+		Publisher *Author
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "has no matching field") {
+				t.Fatal(r)
+			}
+		}()
+
+		_ = sqlt.QueryStmt[struct{}, Book](
+			sqlt.Parse(`SELECT {{ ScanAll Dest "p.name AS Publisher.Name" }} FROM books`),
+		)
+	}()
+
+	books, err := sqlt.QueryStmt[struct{}, Book](
+		sqlt.Parse(`SELECT {{ ScanAll Dest "b.id, b.created, a.name AS Author.Name" }} FROM books b JOIN authors a ON a.id = b.author_id`),
+	).All(context.Background(), db, struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(books) != 2 || books[0].ID != 1 || books[0].Created != "2024" || books[0].Author.Name != "Tolkien" || books[1].Author.Name != "Pratchett" {
+		t.Fatal(books)
+	}
+
+	books, err = sqlt.QueryStmt[struct{}, Book](
+		sqlt.Parse(`SELECT {{ ScanInt64 Dest.Model.ID "b.id" }}, {{ ScanString Dest.Author.Name "a.name" }} FROM books b JOIN authors a ON a.id = b.author_id`),
+	).All(context.Background(), db, struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(books) != 1 || books[0].ID != 2 || books[0].Author.Name != "Rowling" {
+		t.Fatal(books)
+	}
+}
+
+func TestReadWriteSplit(t *testing.T) {
+	primary, primaryMock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replica, replicaMock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primaryMock.ExpectExec("DELETE FROM books WHERE id = ?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	replicaMock.ExpectQuery("SELECT title FROM books WHERE id = ?").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"title"}).AddRow("A"))
+	replicaMock.ExpectQuery("SELECT title FROM books WHERE id = ?").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"title"}).AddRow("B"))
+	primaryMock.ExpectQuery("INSERT INTO books (title) VALUES (?) RETURNING id").WithArgs("C").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	replicaMock.ExpectQuery("SELECT id FROM books WHERE title = ?").WithArgs("C").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+
+	db := sqlt.ReadWriteSplit(primary, replica)
+
+	if _, err = sqlt.Stmt[int64](sqlt.Parse(`DELETE FROM books WHERE id = {{ . }}`)).Exec(context.Background(), db, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	stmt := sqlt.QueryStmt[int64, string](
+		sqlt.ReadOnly(true),
+		sqlt.Parse(`SELECT {{ ScanString Dest "title" }} FROM books WHERE id = {{ . }}`),
+	)
+
+	if titles, err := stmt.All(context.Background(), db, 2); err != nil || len(titles) != 1 || titles[0] != "A" {
+		t.Fatal(titles, err)
+	}
+
+	if title, err := stmt.First(context.Background(), db, 3); err != nil || title != "B" {
+		t.Fatal(title, err)
+	}
+
+	insert := sqlt.QueryStmt[string, int64](sqlt.Parse(`INSERT INTO books (title) VALUES ({{ . }}) RETURNING id`))
+
+	if id, err := insert.One(context.Background(), db, "C"); err != nil || id != 4 {
+		t.Fatal(id, err)
+	}
+
+	query := sqlt.QueryStmt[string, int64](sqlt.Parse(`SELECT id FROM books WHERE title = {{ . }}`))
+
+	if id, err := query.One(sqlt.WithReadOnly(context.Background()), db, "C"); err != nil || id != 4 {
+		t.Fatal(id, err)
+	}
+
+	if err = primaryMock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = replicaMock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExecReturned(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("UPDATE books SET price = price * 2 WHERE author = $1 RETURNING id").WithArgs("Tolkien").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectQuery("UPDATE books SET price = price * 2 WHERE author = $1 RETURNING id").WithArgs("Rowling").
+		WillReturnError(errors.New("boom"))
+
+	stmt := sqlt.QueryStmt[string, int64](
+		sqlt.Postgres(),
+		sqlt.Parse(`UPDATE books SET price = price * 2 WHERE author = {{ . }} RETURNING {{ ScanInt64 Dest "id" }}`),
+	)
+
+	returned, err := stmt.ExecReturned(context.Background(), db, "Tolkien")
+	if err != nil || returned.Affected != 2 || len(returned.Rows) != 2 || returned.Rows[1] != 2 {
+		t.Fatal(returned, err)
+	}
+
+	returned, err = stmt.ExecReturned(context.Background(), db, "Rowling")
+	if err == nil || returned.Affected != 0 || returned.Rows != nil {
+		t.Fatal(returned, err)
+	}
+}
+
+func TestFoldCase(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT id AS userid, name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"userid", "name"}).AddRow(1, "A"))
+
+	type User struct {
+		UserID int64
+		Name   string `sql:"Name"`
+	}
+
+	users, err := sqlt.QueryStmt[struct{}, User](
+		sqlt.FoldCase(true),
+		sqlt.Parse(`SELECT {{ ScanAll Dest "id AS userid, name" }} FROM users`),
+	).All(context.Background(), db, struct{}{})
+	if err != nil || len(users) != 1 || users[0].UserID != 1 || users[0].Name != "A" {
+		t.Fatal(users, err)
+	}
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "has no matching field") {
+			t.Fatal(r)
+		}
+	}()
+
+	_ = sqlt.QueryStmt[struct{}, User](
+		sqlt.Parse(`SELECT {{ ScanAll Dest "id AS userid" }} FROM users`),
+	)
+}
+
+func TestIntervalArgs(t *testing.T) {
+	for _, c := range []struct {
+		config sqlt.Config
+		arg    time.Duration
+		want   any
+	}{
+		{config: sqlt.Postgres(), arg: 90 * time.Minute, want: "90 minutes"},
+		{config: sqlt.Postgres(), arg: 2 * time.Hour, want: "2 hours"},
+		{config: sqlt.Postgres(), arg: 1500 * time.Millisecond, want: "1500 milliseconds"},
+		{config: sqlt.Postgres(), arg: 1500 * time.Nanosecond, want: "2 microseconds"},
+		{config: sqlt.Postgres(), arg: 7 * time.Nanosecond, want: "0 hours"},
+		{config: sqlt.Postgres(), arg: time.Second + 499*time.Nanosecond, want: "1 seconds"},
+		{config: sqlt.Postgres(), arg: 0, want: "0 hours"},
+		{config: sqlt.Sqlite(), arg: 90 * time.Second, want: float64(90)},
+	} {
+		expr, err := sqlt.Stmt[time.Duration](
+			c.config,
+			sqlt.IntervalArgs(true),
+			sqlt.Parse(`DELETE FROM sessions WHERE age > {{ . }}`),
+		).Render(context.Background(), c.arg)
+		if err != nil || len(expr.Args) != 1 || expr.Args[0] != c.want {
+			t.Fatal(expr, err)
+		}
+	}
+
+	expr, err := sqlt.Stmt[time.Duration](
+		sqlt.Postgres(),
+		sqlt.Parse(`DELETE FROM sessions WHERE age > {{ . }}`),
+	).Render(context.Background(), time.Second)
+	if err != nil || expr.Args[0] != time.Second {
+		t.Fatal(expr, err)
+	}
+}
+
+func TestFromTemplate(t *testing.T) {
+	funcs := template.FuncMap{
+		"Table": func() sqlt.Raw {
+			return "books"
+		},
+		"ScanString": func(dest *string, str string) (sqlt.Scanner, error) {
+			return sqlt.Scanner{}, nil
+		},
+		"Dest": func() *string {
+			return nil
+		},
+	}
+
+	tpl := template.Must(template.New("query").Funcs(funcs).Parse(`{{ define "where" }}WHERE author = {{ . }}{{ end }}SELECT {{ ScanString Dest "title" }} FROM {{ Table }} {{ template "where" . }}`))
+
+	expr, err := sqlt.QueryStmt[string, string](
+		sqlt.Funcs(template.FuncMap{
+			"Table": func() sqlt.Raw {
+				return "books"
+			},
+		}),
+		sqlt.FromTemplate(tpl),
+	).Render(context.Background(), "Tolkien")
+	if err != nil || expr.SQL != "SELECT title FROM books WHERE author = ?" || len(expr.Args) != 1 || expr.Args[0] != "Tolkien" {
+		t.Fatal(expr, err)
+	}
+}
+
+func TestAssertDeterministic(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT id, title FROM books WHERE id = ?").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(1, "A"))
+
+	type Book struct {
+		ID    int64
+		Title string
+	}
+
+	book, err := sqlt.QueryStmt[int64, Book](
+		sqlt.AssertDeterministic(true),
+		sqlt.Parse(`SELECT {{ ScanInt64 Dest.ID "id" }}, {{ ScanString Dest.Title "title" }} FROM books WHERE id = {{ . }}`),
+	).First(context.Background(), db, 1)
+	if err != nil || book.ID != 1 || book.Title != "A" {
+		t.Fatal(book, err)
+	}
+
+	var counter int64
+
+	_, err = sqlt.Stmt[struct{}](
+		sqlt.AssertDeterministic(true),
+		sqlt.Funcs(template.FuncMap{
+			"Next": func() int64 {
+				counter++
+
+				return counter
+			},
+		}),
+		sqlt.Parse(`DELETE FROM books WHERE id = {{ Next }}`),
+	).Render(context.Background(), struct{}{})
+	if err == nil || !strings.Contains(err.Error(), "non-deterministic template") {
+		t.Fatal(err)
+	}
+}
+
+func TestScanIntBool(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT active, deleted FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"active", "deleted"}).AddRow(1, "Y").AddRow(0, "n").AddRow(nil, nil))
+	mock.ExpectQuery("SELECT active, deleted FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"active", "deleted"}).AddRow(1, "X"))
+
+	type User struct {
+		Active  bool
+		Deleted bool
+	}
+
+	stmt := sqlt.QueryStmt[struct{}, User](
+		sqlt.Parse(`SELECT {{ ScanIntBool Dest.Active "active" }}, {{ ScanYNBool Dest.Deleted "deleted" }} FROM users`),
+	)
+
+	users, err := stmt.All(context.Background(), db, struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(users) != 3 || !users[0].Active || !users[0].Deleted || users[1].Active || users[1].Deleted || users[2].Active || users[2].Deleted {
+		t.Fatal(users)
+	}
+
+	if _, err = stmt.All(context.Background(), db, struct{}{}); err == nil || !strings.Contains(err.Error(), "invalid boolean 'X'") {
+		t.Fatal(err)
+	}
+}
+
+func TestQueryFunc(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT title FROM books WHERE author = ?").WithArgs("Tolkien").
+		WillReturnRows(sqlmock.NewRows([]string{"title"}).AddRow("A").AddRow("B")).RowsWillBeClosed()
+	mock.ExpectQuery("SELECT title FROM books WHERE author = ?").WithArgs("Rowling").
+		WillReturnRows(sqlmock.NewRows([]string{"title"}).AddRow("C").RowError(0, errors.New("boom"))).RowsWillBeClosed()
+
+	stmt := sqlt.Stmt[string](
+		sqlt.Parse(`SELECT title FROM books WHERE author = {{ . }}`),
+	)
+
+	var titles []string
+
+	if err = stmt.QueryFunc(context.Background(), db, "Tolkien", func(rows *sql.Rows) error {
+		for rows.Next() {
+			var title string
+
+			if err := rows.Scan(&title); err != nil {
+				return err
+			}
+
+			titles = append(titles, title)
+		}
+
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(titles, ",") != "A,B" {
+		t.Fatal(titles)
+	}
+
+	if err = stmt.QueryFunc(context.Background(), db, "Rowling", func(rows *sql.Rows) error {
+		for rows.Next() {
+		}
+
+		return nil
+	}); err == nil || err.Error() != "boom" {
+		t.Fatal(err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrepareInUse(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := mock.ExpectPrepare("DELETE FROM books WHERE title = ?").WillBeClosed()
+	first.ExpectExec().WithArgs("A").WillReturnError(errors.New("retry"))
+
+	second := mock.ExpectPrepare("DELETE FROM authors WHERE name = ?").WillBeClosed()
+	second.ExpectExec().WithArgs("B").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	first.ExpectExec().WithArgs("A").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	var stmt *sqlt.Statement[string]
+
+	stmt = sqlt.Stmt[string](
+		sqlt.Prepare(1),
+		sqlt.Retry(2, func(int) time.Duration {
+			// evicts and closes the statement of the running attempt
+			if _, err := stmt.Exec(context.Background(), db, "B"); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := stmt.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			return 0
+		}, func(err error) bool {
+			return err.Error() == "retry"
+		}),
+		sqlt.Parse(`{{ if eq . "A" }}DELETE FROM books WHERE title = {{ . }}{{ else }}DELETE FROM authors WHERE name = {{ . }}{{ end }}`),
+	)
+
+	if _, err = stmt.Exec(context.Background(), db, "A"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckTemplatesAllFields(t *testing.T) {
+	type Param struct {
+		Title string
+	}
+
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatal("The code did not panic")
+		}
+
+		msg := fmt.Sprint(r)
+
+		for _, want := range []string{"template 'where' is not defined", "<.Foo>", "<.Bar>", "<.Baz>", "<.Qux>"} {
+			if !strings.Contains(msg, want) {
+				t.Fatal(want, msg)
+			}
+		}
+
+		if strings.Contains(msg, "<.Title>") {
+			t.Fatal(msg)
+		}
+	}()
+
+	_ = sqlt.Stmt[Param](
+		sqlt.Parse(`{{ define "order" }}ORDER BY {{ .Qux }}{{ end }}` +
+			`SELECT {{ .Foo }}, {{ .Bar }} FROM books WHERE title = {{ .Title }}` +
+			`{{ if .Title }} AND {{ .Baz }} {{ template "where" . }}{{ end }} {{ template "order" . }}`),
+	)
+}
+
+func TestValidateBounded(t *testing.T) {
+	var validated int
+
+	stmt := sqlt.Stmt[sqlt.Raw](
+		sqlt.Validate(func(ctx context.Context, sql string) error {
+			validated++
+
+			return nil
+		}),
+		sqlt.Parse(`SELECT id FROM books WHERE id = {{ . }}`),
+	)
+
+	for i := range 1025 {
+		if _, err := stmt.Render(context.Background(), sqlt.Raw(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, id := range []sqlt.Raw{"1024", "0"} {
+		if _, err := stmt.Render(context.Background(), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if validated != 1026 {
+		t.Fatal(validated)
+	}
+}
+
+func TestTemplateErrorParseFS(t *testing.T) {
+	stmt := sqlt.Stmt[string](
+		sqlt.Funcs(template.FuncMap{
+			"Fail": func() (sqlt.Raw, error) {
+				return "", errors.New("failed")
+			},
+		}),
+		sqlt.ParseFS(fstest.MapFS{
+			"queries/books.sql": {Data: []byte("{{ define \"where\" }}\nWHERE {{ Fail }}\n{{ end }}\nSELECT id FROM books {{ template \"where\" }}")},
+		}, "queries/*.sql"),
+		sqlt.Lookup("books.sql"),
+	)
+
+	_, err := stmt.Render(context.Background(), "")
+
+	var templateErr sqlt.TemplateError
+
+	if !errors.As(err, &templateErr) {
+		t.Fatal(err)
+	}
+
+	if templateErr.Line != 2 || templateErr.Snippet != "     1 | {{ define \"where\" }}\n>    2 | WHERE {{ Fail }}\n     3 | {{ end }}" {
+		t.Fatal(templateErr.Line, templateErr.Snippet)
+	}
+
+	if names := stmt.Templates(); len(names) != 2 || names[0] != "books.sql" || names[1] != "where" {
+		t.Fatal(names)
+	}
+}
+
+func TestExecWithOverrides(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectExec("DELETE FROM books WHERE id = ? LIMIT 1").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM books WHERE id = $1").WithArgs(2).WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	stmt := sqlt.Stmt[int64](
+		sqlt.Postgres(),
+		sqlt.Parse(`DELETE FROM books WHERE id = {{ . }}{{ if IsSqlite }} LIMIT 1{{ end }}`),
+	)
+
+	if _, err = stmt.ExecWith(context.Background(), db, 1, sqlt.Sqlite()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = stmt.ExecWith(context.Background(), db, 2, sqlt.Timeout(time.Millisecond)); err == nil {
+		t.Fatal("expected timeout")
+	}
+
+	_, err = stmt.ExecWith(context.Background(), db, 3, sqlt.Start(func(runner *sqlt.Runner) {}), sqlt.Prepare(10))
+	if err == nil || err.Error() != "options cannot be overridden: Prepare, Start" {
+		t.Fatal(err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetryHooks(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errBusy := errors.New("busy")
+
+	mock.ExpectExec("DELETE FROM books WHERE id = ?").WithArgs(1).WillReturnError(errBusy)
+	mock.ExpectExec("DELETE FROM books WHERE id = ?").WithArgs(1).WillReturnError(errBusy)
+	mock.ExpectExec("DELETE FROM books WHERE id = ?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	var (
+		starts int
+		ends   []string
+	)
+
+	stmt := sqlt.Stmt[int64](
+		sqlt.Start(func(runner *sqlt.Runner) {
+			starts++
+		}),
+		sqlt.End(func(err error, runner *sqlt.Runner) {
+			ends = append(ends, fmt.Sprintf("%d:%v", runner.Attempt, err))
+		}),
+		sqlt.Retry(3, nil, func(err error) bool {
+			return errors.Is(err, errBusy)
+		}),
+		sqlt.Parse(`DELETE FROM books WHERE id = {{ . }}`),
+	)
+
+	if _, err = stmt.Exec(context.Background(), db, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if starts != 3 || strings.Join(ends, ",") != "1:busy,2:busy,3:<nil>" {
+		t.Fatal(starts, ends)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLimitOffsetOrderBy(t *testing.T) {
+	for _, c := range []struct {
+		tpl string
+		ok  bool
+	}{
+		{"SELECT id FROM books ORDER\n  BY id {{ LimitOffset 10 0 }}", true},
+		{"SELECT id FROM books WHERE id IN (SELECT id FROM books ORDER BY id {{ LimitOffset 10 0 }})", true},
+		{"SELECT id FROM (SELECT id FROM books ORDER BY id) b {{ LimitOffset 10 0 }}", false},
+		{"SELECT id FROM books WHERE title = 'ORDER BY' {{ LimitOffset 10 0 }}", false},
+		{`SELECT "ORDER BY" FROM books /* ORDER BY id */ {{ LimitOffset 10 0 }}`, false},
+		{"SELECT id FROM books ORDER BY id; SELECT id FROM books {{ LimitOffset 10 0 }}", false},
+	} {
+		_, err := sqlt.Stmt[struct{}](
+			sqlt.SQLServer(),
+			sqlt.PreserveWhitespace(true),
+			sqlt.Parse(c.tpl),
+		).Render(context.Background(), struct{}{})
+		if (err == nil) != c.ok {
+			t.Fatal(c.tpl, err)
+		}
+	}
+}
+
+func TestExecWithConcurrentRewrite(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.MatchExpectationsInOrder(false)
+
+	const n = 20
+
+	for i := range n {
+		mock.ExpectExec(fmt.Sprintf("DELETE FROM books WHERE id = ? /* %d */", i)).WithArgs(i).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	stmt := sqlt.Stmt[int](
+		sqlt.Rewrite(func(ctx context.Context, sql string, args []any) (string, []any, error) {
+			return sql, args, nil
+		}),
+		sqlt.Rewrite(func(ctx context.Context, sql string, args []any) (string, []any, error) {
+			return sql, args, nil
+		}),
+		sqlt.Rewrite(func(ctx context.Context, sql string, args []any) (string, []any, error) {
+			return sql, args, nil
+		}),
+		sqlt.Parse(`DELETE FROM books WHERE id = {{ . }}`),
+	)
+
+	var wg sync.WaitGroup
+
+	errs := make([]error, n)
+
+	for i := range n {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, errs[i] = stmt.ExecWith(context.Background(), db, i, sqlt.Rewrite(func(ctx context.Context, sql string, args []any) (string, []any, error) {
+				return fmt.Sprintf("%s /* %d */", sql, i), args, nil
+			}))
+		}()
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWhitespaceOutsideLiterals(t *testing.T) {
+	for _, c := range []struct {
+		config   sqlt.Config
+		tpl      string
+		expected string
+	}{
+		{
+			sqlt.Postgres(),
+			"SELECT id -- the author's name\n  FROM t   WHERE name = 'a  b'",
+			"SELECT id -- the author's name\n FROM t WHERE name = 'a  b'",
+		},
+		{
+			sqlt.MySQL(),
+			`SELECT {{ Ident "o'x" }}   FROM t WHERE name = 'a  b'`,
+			"SELECT `o'x` FROM t WHERE name = 'a  b'",
+		},
+		{
+			sqlt.Postgres(),
+			`SELECT "o'x", /* it's   here */   id FROM t WHERE name = 'a  b'`,
+			`SELECT "o'x", /* it's   here */ id FROM t WHERE name = 'a  b'`,
+		},
+		{
+			sqlt.SQLServer(),
+			`SELECT [o'x]   FROM t WHERE name = 'a  b'`,
+			`SELECT [o'x] FROM t WHERE name = 'a  b'`,
+		},
+	} {
+		expr, err := sqlt.Stmt[struct{}](c.config, sqlt.Parse(c.tpl)).Render(context.Background(), struct{}{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expr.SQL != c.expected {
+			t.Fatalf("%q", expr.SQL)
+		}
+	}
+}
+
+func TestIterPanic(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT id FROM books").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+
+	var ended []error
+
+	end := sqlt.End(func(err error, runner *sqlt.Runner) {
+		ended = append(ended, err)
+	})
+
+	stmt := sqlt.QueryStmt[int64, int64](
+		end,
+		sqlt.Funcs(template.FuncMap{
+			"ScanPanic": func(value *int64, str string) sqlt.Scanner {
+				return sqlt.Scanner{
+					SQL:   str,
+					Value: value,
+					Map: func() error {
+						panic("mapper")
+					},
+				}
+			},
+		}),
+		sqlt.Parse(`SELECT {{ ScanPanic Dest "id" }} FROM books`),
+	)
+
+	for _, err = range stmt.Iter(context.Background(), db, 0) {
+		if err == nil || !strings.Contains(err.Error(), "mapper") {
+			t.Fatal(err)
+		}
+	}
+
+	validated := sqlt.QueryStmt[int64, int64](
+		end,
+		sqlt.ValidateParam(func(param any) error {
+			panic("validate")
+		}),
+		sqlt.Parse(`SELECT id FROM books`),
+	)
+
+	for _, err = range validated.Iter(context.Background(), db, 0) {
+		if err == nil || !strings.Contains(err.Error(), "validate") {
+			t.Fatal(err)
+		}
+	}
+
+	if len(ended) != 2 || !strings.Contains(fmt.Sprint(ended[0]), "mapper") || !strings.Contains(fmt.Sprint(ended[1]), "validate") {
+		t.Fatal(ended)
+	}
+
+	mock.ExpectQuery("SELECT id FROM books").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	func() {
+		defer func() {
+			if r := recover(); r != "body" {
+				t.Fatal(r)
+			}
+		}()
+
+		for range sqlt.QueryStmt[int64, int64](end, sqlt.Parse(`SELECT id FROM books`)).Iter(context.Background(), db, 0) {
+			panic("body")
+		}
+	}()
+
+	if len(ended) != 3 || ended[2] != nil {
+		t.Fatal(ended)
+	}
+}
@@ -0,0 +1,54 @@
+// Package sqlttest provides helpers to test the sql rendered by sqlt statements without a database.
+package sqlttest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wroge/sqlt"
+)
+
+// Renderer is implemented by *sqlt.Statement and *sqlt.QueryStatement.
+type Renderer[Param any] interface {
+	Render(ctx context.Context, param Param) (sqlt.Expression, error)
+}
+
+// AssertSQL renders stmt with param and reports an error, if the sql or the args differ from the expected values.
+// Whitespace is normalized before the sql is compared.
+func AssertSQL[Param any](t testing.TB, stmt Renderer[Param], param Param, wantSQL string, wantArgs ...any) {
+	t.Helper()
+
+	expr, err := stmt.Render(context.Background(), param)
+	if err != nil {
+		t.Errorf("render: %v", err)
+
+		return
+	}
+
+	if got, want := normalize(expr.SQL), normalize(wantSQL); got != want {
+		t.Errorf("sql mismatch:\n%s", diff(want, got))
+	}
+
+	if len(expr.Args) != len(wantArgs) || (len(wantArgs) > 0 && !reflect.DeepEqual(expr.Args, wantArgs)) {
+		t.Errorf("args mismatch:\nwant: %#v\ngot:  %#v", wantArgs, expr.Args)
+	}
+}
+
+// normalize replaces consecutive whitespace by a single space.
+func normalize(str string) string {
+	return strings.Join(strings.Fields(str), " ")
+}
+
+// diff returns want and got with a marker at the first differing byte.
+func diff(want, got string) string {
+	i := 0
+
+	for i < len(want) && i < len(got) && want[i] == got[i] {
+		i++
+	}
+
+	return fmt.Sprintf("want: %s\ngot:  %s\n      %s^", want, got, strings.Repeat(" ", i))
+}
@@ -0,0 +1,51 @@
+package sqlttest_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/wroge/sqlt"
+	"github.com/wroge/sqlt/sqlttest"
+)
+
+type recorder struct {
+	testing.TB
+	errs []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+func TestAssertSQL(t *testing.T) {
+	stmt := sqlt.Stmt[string](
+		sqlt.Postgres(),
+		sqlt.Parse(`
+			SELECT id
+			FROM books
+			WHERE title = {{ . }}
+		`),
+	)
+
+	sqlttest.AssertSQL(t, stmt, "TEST", "SELECT id FROM books WHERE title = $1", "TEST")
+
+	rec := &recorder{TB: t}
+
+	sqlttest.AssertSQL(rec, stmt, "TEST", "SELECT id FROM books WHERE name = $1", "OTHER")
+
+	if len(rec.errs) != 2 || !strings.Contains(rec.errs[0], "sql mismatch") || !strings.HasSuffix(rec.errs[0], "\n"+strings.Repeat(" ", 33)+"^") ||
+		!strings.Contains(rec.errs[1], "args mismatch") {
+		t.Fatal(rec.errs)
+	}
+
+	rec = &recorder{TB: t}
+
+	sqlttest.AssertSQL(rec, sqlt.Stmt[string](sqlt.Parse(`SELECT {{ Ident . }}`)), "\x00", "SELECT 1")
+
+	if len(rec.errs) != 1 || !strings.HasPrefix(rec.errs[0], "render: ") {
+		t.Fatal(rec.errs)
+	}
+}